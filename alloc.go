@@ -0,0 +1,269 @@
+package registry
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+const (
+	binAlignment  = 4096 // hive bins size is a multiple of 4KB
+	cellAlignment = 8    // cells size is a multiple of 8 bytes
+	minCellSize   = 8    // smallest cell a free cell can be split into
+)
+
+// hiveBin is the location of a "hbin", relative to the start of the hive bins data
+type hiveBin struct {
+	offset uint32
+	size   uint32
+}
+
+// freeCell is a free cell, relative to the start of the hive bins data
+type freeCell struct {
+	offset uint32
+	size   uint32
+}
+
+// cellAllocator manages the cells of a writable registry.
+// Offsets are relative to the start of the hive bins data and point
+// to the cell size, as the offsets stored in the registry structures.
+type cellAllocator struct {
+	rws    io.ReadWriteSeeker
+	header *header
+
+	bins      []hiveBin
+	freeCells []freeCell // sorted by offset
+}
+
+// newCellAllocator reads every hive bin and records the free cells
+func newCellAllocator(rws io.ReadWriteSeeker, h *header) (*cellAllocator, error) {
+	a := &cellAllocator{rws: rws, header: h}
+
+	b := make([]byte, hiveBinsDataStart)
+	for off := uint32(0); off < h.binSize; {
+		err := a.readAt(off, b)
+		if err != nil {
+			return nil, err
+		}
+		if string(b[:4]) != binHeaderSig {
+			return nil, errorW{err: ErrCorruptRegistry, cause: errInvalidBinHeader, function: "newCellAllocator"}
+		}
+		size := binary.LittleEndian.Uint32(b[8:12])
+		if size < binAlignment || size%binAlignment != 0 {
+			return nil, errorW{err: ErrCorruptRegistry, cause: errInvalidBinHeader, function: "newCellAllocator"}
+		}
+		bin := hiveBin{offset: off, size: size}
+		a.bins = append(a.bins, bin)
+
+		err = a.scanBin(bin)
+		if err != nil {
+			return nil, err
+		}
+		off += size
+	}
+	return a, nil
+}
+
+// scanBin appends the free cells of bin to a.freeCells
+func (a *cellAllocator) scanBin(bin hiveBin) error {
+	b := make([]byte, cellHeaderSize)
+	end := bin.offset + bin.size
+	for off := bin.offset + hiveBinsDataStart; off < end; {
+		err := a.readAt(off, b)
+		if err != nil {
+			return err
+		}
+		size := int32(binary.LittleEndian.Uint32(b))
+		if size < 0 {
+			size = -size
+		} else if size > 0 {
+			a.freeCells = append(a.freeCells, freeCell{offset: off, size: uint32(size)})
+		}
+		if size == 0 || size%cellAlignment != 0 || off+uint32(size) > end {
+			return errorW{err: ErrCorruptRegistry, cause: errInvalidCellSize, function: "cellAllocator.scanBin"}
+		}
+		off += uint32(size)
+	}
+	return nil
+}
+
+// alloc allocates a cell able to hold size bytes of data and returns its offset.
+// The data of the cell is zeroed.
+func (a *cellAllocator) alloc(size int) (uint32, error) {
+	need := uint32(align(size+cellHeaderSize, cellAlignment))
+
+	i := a.fit(need)
+	if i < 0 {
+		err := a.grow(need)
+		if err != nil {
+			return 0, err
+		}
+		i = a.fit(need)
+	}
+
+	c := a.freeCells[i]
+	if c.size-need >= minCellSize {
+		a.freeCells[i] = freeCell{offset: c.offset + need, size: c.size - need}
+		err := a.writeSize(a.freeCells[i].offset, int32(a.freeCells[i].size))
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		need = c.size
+		a.freeCells = append(a.freeCells[:i], a.freeCells[i+1:]...)
+	}
+
+	err := a.writeSize(c.offset, -int32(need))
+	if err != nil {
+		return 0, err
+	}
+	return c.offset, a.writeAt(c.offset+cellHeaderSize, make([]byte, need-cellHeaderSize))
+}
+
+// fit returns the index of the first free cell of at least size bytes or -1
+func (a *cellAllocator) fit(size uint32) int {
+	for i, c := range a.freeCells {
+		if c.size >= size {
+			return i
+		}
+	}
+	return -1
+}
+
+// grow appends a hive bin with a free cell of at least size bytes
+func (a *cellAllocator) grow(size uint32) error {
+	bin := hiveBin{
+		offset: a.header.binSize,
+		size:   uint32(align(int(size)+hiveBinsDataStart, binAlignment)),
+	}
+
+	cell := freeCell{offset: bin.offset + hiveBinsDataStart, size: bin.size - hiveBinsDataStart}
+
+	// the whole bin is written so the file is extended with it
+	b := make([]byte, bin.size)
+	copy(b, binHeaderSig)
+	binary.LittleEndian.PutUint32(b[4:8], bin.offset)
+	binary.LittleEndian.PutUint32(b[8:12], bin.size)
+	binary.LittleEndian.PutUint64(b[20:28], a.header.lastModification)
+	binary.LittleEndian.PutUint32(b[hiveBinsDataStart:], cell.size)
+	err := a.writeAt(bin.offset, b)
+	if err != nil {
+		return err
+	}
+
+	a.bins = append(a.bins, bin)
+	a.freeCells = append(a.freeCells, cell)
+	a.header.binSize += bin.size
+	return a.header.Write()
+}
+
+// free releases the cell at offset, merging it with the free cells around it
+func (a *cellAllocator) free(offset uint32) error {
+	size, err := a.cellSize(offset)
+	if err != nil {
+		return err
+	}
+	if size <= 0 {
+		return errorW{err: ErrCorruptRegistry, cause: errCellNotAllocated, function: "cellAllocator.free"}
+	}
+
+	c := freeCell{offset: offset, size: uint32(size)}
+	i := sort.Search(len(a.freeCells), func(i int) bool { return a.freeCells[i].offset > offset })
+
+	// coalesce with the adjacent free cells. Cells of different
+	// bins are never adjacent, the bin header is between them
+	if i < len(a.freeCells) && c.offset+c.size == a.freeCells[i].offset {
+		c.size += a.freeCells[i].size
+		a.freeCells = append(a.freeCells[:i], a.freeCells[i+1:]...)
+	}
+	if i > 0 && a.freeCells[i-1].offset+a.freeCells[i-1].size == c.offset {
+		i--
+		c.offset = a.freeCells[i].offset
+		c.size += a.freeCells[i].size
+		a.freeCells = append(a.freeCells[:i], a.freeCells[i+1:]...)
+	}
+
+	a.freeCells = append(a.freeCells, freeCell{})
+	copy(a.freeCells[i+1:], a.freeCells[i:])
+	a.freeCells[i] = c
+	return a.writeSize(c.offset, int32(c.size))
+}
+
+// cellSize returns the size of the cell at offset. It is positive
+// for allocated cells and negative for free cells
+func (a *cellAllocator) cellSize(offset uint32) (int32, error) {
+	b := make([]byte, cellHeaderSize)
+	err := a.readAt(offset, b)
+	return -int32(binary.LittleEndian.Uint32(b)), err
+}
+
+// realloc returns a cell able to hold size bytes with the data of the cell at offset.
+// If the cell is big enough it is returned, otherwise it is freed.
+func (a *cellAllocator) realloc(offset uint32, size int) (uint32, error) {
+	old, err := a.cellSize(offset)
+	if err != nil {
+		return 0, err
+	}
+	if int(old)-cellHeaderSize >= size {
+		return offset, nil
+	}
+
+	b := make([]byte, old-cellHeaderSize)
+	err = a.readCell(offset, b)
+	if err != nil {
+		return 0, err
+	}
+	n, err := a.alloc(size)
+	if err != nil {
+		return 0, err
+	}
+	err = a.writeCell(n, b)
+	if err != nil {
+		return 0, err
+	}
+	return n, a.free(offset)
+}
+
+func (a *cellAllocator) writeSize(offset uint32, size int32) error {
+	b := make([]byte, cellHeaderSize)
+	binary.LittleEndian.PutUint32(b, uint32(size))
+	return a.writeAt(offset, b)
+}
+
+// readCell reads the data of the cell at offset
+func (a *cellAllocator) readCell(offset uint32, b []byte) error {
+	return a.readAt(offset+cellHeaderSize, b)
+}
+
+// writeCell writes the data of the cell at offset
+func (a *cellAllocator) writeCell(offset uint32, b []byte) error {
+	return a.writeAt(offset+cellHeaderSize, b)
+}
+
+func (a *cellAllocator) readAt(offset uint32, b []byte) error {
+	_, err := a.rws.Seek(hiveBinsOffset+int64(offset), io.SeekStart)
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "cellAllocator.readAt r.Seek"}
+	}
+	_, err = io.ReadFull(a.rws, b)
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "cellAllocator.readAt io.ReadFull"}
+	}
+	return nil
+}
+
+func (a *cellAllocator) writeAt(offset uint32, b []byte) error {
+	_, err := a.rws.Seek(hiveBinsOffset+int64(offset), io.SeekStart)
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "cellAllocator.writeAt r.Seek"}
+	}
+	_, err = a.rws.Write(b)
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "cellAllocator.writeAt w.Write"}
+	}
+	return nil
+}
+
+func align(n, a int) int {
+	return (n + a - 1) / a * a
+}
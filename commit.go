@@ -0,0 +1,315 @@
+package registry
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"os"
+	"time"
+)
+
+const (
+	logSuffix = ".LOG1"
+
+	logEntrySig        = "HvLE"
+	logEntryHeaderSize = 40
+	logBaseBlockSize   = 512 // log entries start after the base block
+
+	// fileTypeLog is the header file type of transaction logs in the
+	// format introduced by Windows 8.1 (log entries)
+	fileTypeLog = 6
+
+	marvin32Seed = 0x82EF4D887A4E55C5
+)
+
+// Commit writes the changes made to the registry to its file following
+// the Windows protocol: the primary sequence number is incremented and
+// written, then the changed data, then the header with the secondary
+// sequence number matching the primary one. A partly written file is
+// detected by the sequence numbers not matching. If a transaction log
+// is used, it is written first so the changes can be recovered.
+func (r Registry) Commit() error {
+	f := r.file
+	if f == nil {
+		return ErrReadOnly
+	}
+	if len(f.pages) == 0 {
+		return nil
+	}
+
+	h := r.header
+	h.primarySequence++
+	h.lastModification = filetime(time.Now())
+	h.update()
+
+	dirty := f.dirty()
+	if f.log {
+		err := writeLog(f, h, dirty)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := f.writeHeader(h)
+	if err != nil {
+		return err
+	}
+	for _, off := range dirty {
+		if off < hiveBinsOffset {
+			continue
+		}
+		_, err = f.fp.WriteAt(f.pages[off], off)
+		if err != nil {
+			return errorW{err: ErrCorruptRegistry, cause: err, function: "Registry.Commit WriteAt"}
+		}
+	}
+	err = f.fp.Sync()
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "Registry.Commit Sync"}
+	}
+
+	h.secondarySequence = h.primarySequence
+	h.update()
+	err = f.writeHeader(h)
+	if err != nil {
+		return err
+	}
+	f.pages = map[int64][]byte{}
+	return nil
+}
+
+// Flush is Commit, for registries used as io.Writer like files.
+func (r Registry) Flush() error {
+	return r.Commit()
+}
+
+// UseTransactionLog sets whether Commit writes the changes to a
+// transaction log (the hive file name with the .LOG1 suffix) before
+// writing them to the hive. The log lets Windows, or Open, recover a hive
+// that was partly written, at the cost of writing the changes twice.
+func (r Registry) UseTransactionLog(enable bool) error {
+	if r.file == nil {
+		return ErrReadOnly
+	}
+	r.file.log = enable
+	return nil
+}
+
+// atomic calls fn, undoing the changes it made to the registry if it fails
+func (r Registry) atomic(fn func() error) error {
+	f, a, h := r.file, r.cells, r.header
+
+	bins := append([]hiveBin(nil), a.bins...)
+	freeCells := append([]freeCell(nil), a.freeCells...)
+	saved := *h
+	saved.buf = append([]byte(nil), h.buf...)
+	saved.xor = saved.buf[508:512]
+
+	f.begin()
+	err := fn()
+	if err != nil {
+		f.rollback()
+		a.bins = bins
+		a.freeCells = freeCells
+		*h = saved
+		return err
+	}
+	f.end()
+	return nil
+}
+
+// writeHeader writes the header to the file and syncs it
+func (f *hiveFile) writeHeader(h *header) error {
+	_, err := f.fp.WriteAt(h.buf, 0)
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "hiveFile.writeHeader WriteAt"}
+	}
+	err = f.fp.Sync()
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "hiveFile.writeHeader Sync"}
+	}
+	return nil
+}
+
+// writeLog writes the dirty pages of the hive bins in a log file with
+// a single "HvLE" log entry. The log base block is a copy of header h.
+// The entry continues the last complete write of the hive: its sequence
+// number is the secondary sequence number of h.
+func writeLog(f *hiveFile, h *header, dirty []int64) error {
+	base := make([]byte, logBaseBlockSize)
+	copy(base, h.buf)
+	binary.LittleEndian.PutUint32(base[4:8], h.secondarySequence)
+	binary.LittleEndian.PutUint32(base[8:12], h.secondarySequence)
+	binary.LittleEndian.PutUint32(base[28:32], fileTypeLog)
+	copy(base[508:512], checksum(base))
+
+	// dirty page references merge contiguous pages
+	var refs [][2]uint32
+	var data []byte
+	for _, off := range dirty {
+		if off < hiveBinsOffset {
+			continue
+		}
+		page := uint32(off - hiveBinsOffset)
+		if n := len(refs); n > 0 && refs[n-1][0]+refs[n-1][1] == page {
+			refs[n-1][1] += pageSize
+		} else {
+			refs = append(refs, [2]uint32{page, pageSize})
+		}
+		data = append(data, f.pages[off]...)
+	}
+
+	size := align(logEntryHeaderSize+8*len(refs)+len(data), pageSize)
+	e := make([]byte, size)
+	copy(e, logEntrySig)
+	binary.LittleEndian.PutUint32(e[4:8], uint32(size))
+	copy(e[8:12], h.buf[144:148]) // flags
+	binary.LittleEndian.PutUint32(e[12:16], h.secondarySequence)
+	binary.LittleEndian.PutUint32(e[16:20], h.binSize)
+	binary.LittleEndian.PutUint32(e[20:24], uint32(len(refs)))
+	for i, ref := range refs {
+		binary.LittleEndian.PutUint32(e[logEntryHeaderSize+8*i:], ref[0])
+		binary.LittleEndian.PutUint32(e[logEntryHeaderSize+8*i+4:], ref[1])
+	}
+	copy(e[logEntryHeaderSize+8*len(refs):], data)
+	binary.LittleEndian.PutUint64(e[24:32], marvin32(marvin32Seed, e[logEntryHeaderSize:]))
+	binary.LittleEndian.PutUint64(e[32:40], marvin32(marvin32Seed, e[:32]))
+
+	fp, err := os.OpenFile(f.name+logSuffix, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	_, err = fp.Write(append(base, e...))
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "writeLog Write"}
+	}
+	err = fp.Sync()
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "writeLog Sync"}
+	}
+	return fp.Close()
+}
+
+// recoverLog applies the log entries of the transaction log of f to f.
+// The recovered data is kept in memory until committed. Like Windows,
+// the first entry must continue the last complete write of the hive,
+// its sequence number matching the secondary one of the hive, so a log
+// left from an earlier commit is not applied over newer data.
+func recoverLog(f *hiveFile) error {
+	log, err := ioutil.ReadFile(f.name + logSuffix)
+	if err != nil {
+		return err
+	}
+	if len(log) < logBaseBlockSize || string(log[:4]) != registrySig ||
+		string(checksum(log)) != string(log[508:512]) ||
+		binary.LittleEndian.Uint32(log[28:32]) != fileTypeLog {
+		return errorW{err: ErrBadRegistry, cause: errBadSignature, function: "recoverLog"}
+	}
+	base := log[:logBaseBlockSize]
+
+	// the sequence number of the hive header, unless it was partly written
+	hive := make([]byte, logBaseBlockSize)
+	_, err = f.Seek(0, io.SeekStart)
+	if err == nil {
+		_, err = io.ReadFull(f, hive)
+	}
+	if err != nil {
+		return err
+	}
+	seq := binary.LittleEndian.Uint32(base[8:12])
+	if string(checksum(hive)) == string(hive[508:512]) {
+		seq = binary.LittleEndian.Uint32(hive[8:12])
+	}
+	binSize := binary.LittleEndian.Uint32(base[40:44])
+
+	applied := 0
+	for off := logBaseBlockSize; off+logEntryHeaderSize <= len(log); {
+		e := log[off:]
+		size := int(binary.LittleEndian.Uint32(e[4:8]))
+		if string(e[:4]) != logEntrySig || size < logEntryHeaderSize || size%pageSize != 0 || size > len(e) ||
+			binary.LittleEndian.Uint32(e[12:16]) != seq {
+			break
+		}
+		e = e[:size]
+		if marvin32(marvin32Seed, e[logEntryHeaderSize:]) != binary.LittleEndian.Uint64(e[24:32]) ||
+			marvin32(marvin32Seed, e[:32]) != binary.LittleEndian.Uint64(e[32:40]) {
+			break
+		}
+
+		n := int(binary.LittleEndian.Uint32(e[20:24]))
+		data := logEntryHeaderSize + 8*n
+		if data > size {
+			break
+		}
+		for i := 0; i < n; i++ {
+			page := binary.LittleEndian.Uint32(e[logEntryHeaderSize+8*i:])
+			length := int(binary.LittleEndian.Uint32(e[logEntryHeaderSize+8*i+4:]))
+			if data+length > size {
+				return errorW{err: ErrBadRegistry, cause: errShortDataBlock, function: "recoverLog"}
+			}
+			f.Seek(hiveBinsOffset+int64(page), io.SeekStart)
+			_, err = f.Write(e[data : data+length])
+			if err != nil {
+				return err
+			}
+			data += length
+		}
+
+		binSize = binary.LittleEndian.Uint32(e[16:20])
+		seq++
+		applied++
+		off += size
+	}
+	if applied == 0 {
+		return errorW{err: ErrBadRegistry, cause: errBadSequenceNumber, function: "recoverLog"}
+	}
+
+	h := newHeader(f)
+	h.buf = make([]byte, hiveBinsOffset)
+	copy(h.buf, base)
+	h.primarySequence = seq
+	h.secondarySequence = seq
+	h.lastModification = binary.LittleEndian.Uint64(base[12:20])
+	h.rootOffset = binary.LittleEndian.Uint32(base[36:40])
+	h.binSize = binSize
+	return h.Write()
+}
+
+// checksum calculates the XOR of the first 508 bytes of a base block
+func checksum(b []byte) []byte {
+	xor := make([]byte, 4)
+	for i, c := range b[:508] {
+		xor[i&3] ^= c
+	}
+	return xor
+}
+
+// marvin32 calculates the Marvin32 hash of b
+func marvin32(seed uint64, b []byte) uint64 {
+	lo, hi := uint32(seed), uint32(seed>>32)
+	block := func() {
+		hi ^= lo
+		lo = bits.RotateLeft32(lo, 20)
+		lo += hi
+		hi = bits.RotateLeft32(hi, 9)
+		hi ^= lo
+		lo = bits.RotateLeft32(lo, 27)
+		lo += hi
+		hi = bits.RotateLeft32(hi, 19)
+	}
+
+	for ; len(b) >= 4; b = b[4:] {
+		lo += binary.LittleEndian.Uint32(b)
+		block()
+	}
+	final := uint32(0x80)
+	for i := len(b) - 1; i >= 0; i-- {
+		final = final<<8 | uint32(b[i])
+	}
+	lo += final
+	block()
+	block()
+	return uint64(hi)<<32 | uint64(lo)
+}
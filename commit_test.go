@@ -0,0 +1,183 @@
+package registry
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMarvin32(t *testing.T) {
+	const seed = 0x004FB61A001BDBCC
+	tests := []struct {
+		data []byte
+		want uint64
+	}{
+		{[]byte{}, 0x30ED35C100CD3C7D},
+		{[]byte{0xAF}, 0x48E73FC77D75DDC1},
+		{[]byte{0xE7, 0x0F}, 0xB5F6E1FC485DBFF8},
+		{[]byte{0x37, 0xF4, 0x95}, 0xF0B07C789B8CF7E8},
+		{[]byte{0x86, 0x42, 0xDC, 0x59}, 0x7008F2E87E9CF556},
+		{[]byte{0x15, 0x3F, 0xB7, 0x98, 0x26}, 0xE6C08C6DA2AFA997},
+		{[]byte{0x09, 0x32, 0xE6, 0x24, 0x6C, 0x47}, 0x6F04BF1A5EA24060},
+		{[]byte{0xAB, 0x42, 0x7E, 0xA8, 0xD1, 0x0F, 0xC7}, 0xE11847E4F0678C41},
+	}
+	for _, tt := range tests {
+		if got := marvin32(seed, tt.data); got != tt.want {
+			t.Errorf("marvin32(%x) = %#x, want %#x", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestRegistry_Commit(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	seq := r.header.primarySequence
+
+	k, err := r.OpenKey("Environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.SetStringValue("Committed", "yes"); err != nil {
+		t.Fatal(err)
+	}
+
+	// changes are not written until committed
+	saved, err := OpenKey(file, "Environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := saved.GetStringValue("Committed"); err != ErrNotExist {
+		t.Errorf("uncommitted value error = %v, want %v", err, ErrNotExist)
+	}
+	saved.Close()
+
+	if err := r.Commit(); err != nil {
+		t.Fatalf("Registry.Commit() error = %v", err)
+	}
+
+	saved, err = OpenKey(file, "Environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer saved.Close()
+	if s, _, err := saved.GetStringValue("Committed"); s != "yes" || err != nil {
+		t.Errorf("committed value = %v %v", s, err)
+	}
+	h := saved.registry.header
+	if h.primarySequence != seq+1 || h.secondarySequence != seq+1 {
+		t.Errorf("sequence numbers = %v %v, want %v", h.primarySequence, h.secondarySequence, seq+1)
+	}
+
+	if err := (Registry{}).Commit(); err != ErrReadOnly {
+		t.Errorf("Registry.Commit() of read only registry error = %v, want %v", err, ErrReadOnly)
+	}
+}
+
+func TestRegistry_Commit_recoverLog(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.UseTransactionLog(true); err != nil {
+		t.Fatal(err)
+	}
+	k, err := r.OpenKey("Environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.SetBinaryValue("Recovered", make([]byte, 20000)); err != nil {
+		t.Fatal(err)
+	}
+
+	// stop the commit after writing the log and the first header
+	h := r.header
+	h.primarySequence++
+	h.update()
+	if err := writeLog(r.file, h, r.file.dirty()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.file.writeHeader(h); err != nil {
+		t.Fatal(err)
+	}
+	r.fp.Close()
+
+	k, err = OpenKey(file, "Environment")
+	if err != nil {
+		t.Fatalf("OpenKey() of partly written hive error = %v", err)
+	}
+	if b, _, err := k.GetBinaryValue("Recovered"); len(b) != 20000 || err != nil {
+		t.Errorf("recovered value = %v %v", len(b), err)
+	}
+	k.Close()
+
+	// a writable registry commits the recovered data
+	r, err = OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(file + logSuffix); err != nil {
+		t.Fatal(err)
+	}
+	k, err = OpenKey(file, "Environment")
+	if err != nil {
+		t.Fatalf("OpenKey() of recovered hive error = %v", err)
+	}
+	defer k.Close()
+	if b, _, err := k.GetBinaryValue("Recovered"); len(b) != 20000 || err != nil {
+		t.Errorf("recovered value = %v %v", len(b), err)
+	}
+}
+
+func TestRegistry_Commit_staleLog(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.UseTransactionLog(true); err != nil {
+		t.Fatal(err)
+	}
+	k, err := r.OpenKey("Environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.SetBinaryValue("Stale", make([]byte, 20000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the log of the first commit is left while the next one is not logged
+	if err := r.UseTransactionLog(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.SetStringValue("Next", "next"); err != nil {
+		t.Fatal(err)
+	}
+	h := r.header
+	h.primarySequence++
+	h.update()
+	if err := r.file.writeHeader(h); err != nil {
+		t.Fatal(err)
+	}
+	r.fp.Close()
+
+	_, err = OpenKey(file, "Environment")
+	if err == nil || err.Error() != ErrBadRegistry.Error() {
+		t.Errorf("OpenKey() with a stale log error = %v, want %v", err, ErrBadRegistry)
+	}
+}
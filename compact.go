@@ -0,0 +1,336 @@
+package registry
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+// latestMinor is the minor version of the hives written by Compact,
+// the format of Windows XP and later (REG_LATEST_FORMAT)
+const latestMinor = 5
+
+// Compact writes the keys and values of the registry to dst as a new
+// hive without free space: cells are packed in order, subkey lists
+// are sorted and security descriptors are stored once.
+// Compact returns the size of the registry and the size of the new hive.
+func (r Registry) Compact(dst io.Writer) (before, after int64, err error) {
+	root, err := r.OpenKey("")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	b, err := newHiveBuilder(r.header.buf[48 : 48+fileNameSize])
+	if err != nil {
+		return 0, 0, err
+	}
+	off, err := b.copyKey(root, root.Name(), noOffset)
+	if err != nil {
+		return 0, 0, err
+	}
+	after, err = b.writeTo(dst, off)
+	return hiveBinsOffset + int64(r.header.binSize), after, err
+}
+
+// hiveBuilder copies keys to a hive, a new one built in memory or
+// a registry opened for writing
+type hiveBuilder struct {
+	rws io.ReadWriteSeeker
+	a   *cellAllocator
+
+	security      map[string]uint32 // "sk" cell offset by security descriptor
+	firstSecurity uint32
+
+	lastModified uint64 // if not zero, the last write time of the keys
+}
+
+// newHiveBuilder returns a builder of a new hive in memory
+func newHiveBuilder(fileName []byte) (*hiveBuilder, error) {
+	m := &memFile{}
+	h, err := newBaseBlock(m, latestMinor, fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &hiveBuilder{
+		rws:           m,
+		a:             &cellAllocator{rws: m, header: h},
+		security:      map[string]uint32{},
+		firstSecurity: noOffset,
+	}, nil
+}
+
+// newKeyCopier returns a builder copying keys to the writable registry r.
+// The security descriptors of r are reused.
+func newKeyCopier(r Registry) (*hiveBuilder, error) {
+	b := &hiveBuilder{
+		rws:           r.rws,
+		a:             r.cells,
+		security:      map[string]uint32{},
+		firstSecurity: r.root.securityKeyOffset,
+	}
+
+	seen := map[uint32]bool{}
+	for off := b.firstSecurity; ; {
+		if seen[off] {
+			// a loop not going through the first cell
+			return nil, errorW{err: ErrCorruptRegistry, cause: errBadSignature, function: "newKeyCopier"}
+		}
+		seen[off] = true
+
+		sk := newSecurityKey(b.rws, hiveBinsOffset+cellHeaderSize, off)
+		err := sk.Read()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := b.security[string(sk.ntSecurityDescriptor)]; !ok {
+			b.security[string(sk.ntSecurityDescriptor)] = off
+		}
+		off = sk.nextKeyOffset
+		if off == b.firstSecurity {
+			return b, nil
+		}
+	}
+}
+
+// writeTo writes the hive with the root key at offset root to w
+func (b *hiveBuilder) writeTo(w io.Writer, root uint32) (int64, error) {
+	h := b.a.header
+	h.rootOffset = root
+	err := h.Write()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b.rws.(*memFile).b)
+	return int64(n), err
+}
+
+// copyKey copies src, its values and subkeys as subkey name of the key
+// at offset parent and returns the offset of the copy. The copy is not
+// added to the subkey list of parent. If parent is noOffset, the copy is
+// the root key of the hive.
+func (b *hiveBuilder) copyKey(src Key, name string, parent uint32) (uint32, error) {
+	a := b.a
+	src = newKey(src.registry, src.rws, src.nk) // keep the cursor of src
+
+	flags := src.nk.flags &^ (nk_KEY_COMP_NAME | nk_KEY_HIVE_ENTRY | nk_KEY_IS_VOLATILE)
+	if src.nk.isRoot() {
+		flags &^= nk_KEY_NO_DELETE
+	}
+	if parent == noOffset {
+		flags |= nk_KEY_HIVE_ENTRY | nk_KEY_NO_DELETE
+	}
+	// the key is allocated first, the root key must be the first cell
+	off, err := newNamedKeyCell(a, name, parent, noOffset, flags)
+	if err != nil {
+		return 0, err
+	}
+
+	nk := newNamedKey(b.rws, hiveBinsOffset+cellHeaderSize, hiveBinsOffset+cellHeaderSize+int64(off))
+	err = nk.Read()
+	if err != nil {
+		return 0, err
+	}
+	nk.lastModified = src.nk.lastModified
+	if b.lastModified != 0 {
+		nk.lastModified = b.lastModified
+	}
+	nk.securityKeyOffset, err = b.copySecurity(src)
+	if err != nil {
+		return 0, err
+	}
+
+	if src.nk.classNameSize > 0 && src.nk.classNameOffset != noOffset {
+		class, err := readCellData(src.rws, src.nk.binOffset, src.nk.classNameOffset, uint32(src.nk.classNameSize))
+		if err != nil {
+			return 0, err
+		}
+		nk.classNameOffset, err = a.alloc(len(class))
+		if err != nil {
+			return 0, err
+		}
+		err = a.writeCell(nk.classNameOffset, class)
+		if err != nil {
+			return 0, err
+		}
+		nk.classNameSize = uint16(len(class))
+	}
+
+	err = b.copyValues(src, nk)
+	if err != nil {
+		return 0, err
+	}
+
+	names, err := src.ReadSubKeyNames(-1)
+	if err != nil {
+		return 0, err
+	}
+	entries := make([]subKeyEntry, 0, len(names))
+	for _, name := range names {
+		sub, err := src.OpenSubKey(name)
+		if err != nil {
+			return 0, err
+		}
+		child, err := b.copyKey(sub, name, off)
+		if err != nil {
+			return 0, err
+		}
+		entries = append(entries, subKeyEntry{offset: child, name: name})
+
+		if n := utf16Size(name); n > nk.largestSubKeyNameSize {
+			nk.largestSubKeyNameSize = n
+		}
+		if n := uint32(sub.nk.classNameSize); n > nk.largestSubKeyClassNameSize {
+			nk.largestSubKeyClassNameSize = n
+		}
+	}
+	if len(entries) > 0 {
+		nk.subKeysListOffset, err = b.subKeyList(entries)
+		if err != nil {
+			return 0, err
+		}
+		nk.numberOfSubKeys = uint32(len(entries))
+	}
+
+	return off, nk.Write()
+}
+
+// copyValues copies the values of src to the key nk, keeping their order
+func (b *hiveBuilder) copyValues(src Key, nk *namedKey) error {
+	a := b.a
+	list := src.nk.values
+
+	offsets := make([]uint32, 0, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		// the cell is copied as stored, only the data is moved
+		vk := make([]byte, 20)
+		_, err := src.rws.Seek(src.nk.binOffset+int64(list.offsets[i]), io.SeekStart)
+		if err != nil {
+			return errorW{err: ErrCorruptRegistry, cause: err, function: "hiveBuilder.copyValues r.Seek"}
+		}
+		_, err = io.ReadFull(src.rws, vk)
+		if err != nil {
+			return errorW{err: ErrCorruptRegistry, cause: err, function: "hiveBuilder.copyValues io.ReadFull"}
+		}
+		if string(vk[:2]) != valueKeySig {
+			return errorW{err: ErrCorruptRegistry, cause: errBadSignature, function: "hiveBuilder.copyValues"}
+		}
+		name := make([]byte, binary.LittleEndian.Uint16(vk[2:4]))
+		_, err = io.ReadFull(src.rws, name)
+		if err != nil {
+			return errorW{err: ErrCorruptRegistry, cause: err, function: "hiveBuilder.copyValues io.ReadFull"}
+		}
+
+		size := binary.LittleEndian.Uint32(vk[4:8])
+		if size&dataInline == 0 && size > 0 {
+			data, err := readCellData(src.rws, src.nk.binOffset, binary.LittleEndian.Uint32(vk[8:12]), size)
+			if err != nil {
+				return err
+			}
+			stored, offset, err := storeValueData(a, data)
+			if err != nil {
+				return err
+			}
+			binary.LittleEndian.PutUint32(vk[4:8], stored)
+			binary.LittleEndian.PutUint32(vk[8:12], offset)
+		}
+		if n := size &^ dataInline; n > nk.largestValueDataSize {
+			nk.largestValueDataSize = n
+		}
+		n := uint32(len(name))
+		if binary.LittleEndian.Uint16(vk[16:18])&vk_VALUE_COMP_NAME != 0 {
+			n *= 2
+		}
+		if n > nk.largestValueNameSize {
+			nk.largestValueNameSize = n
+		}
+
+		off, err := a.alloc(len(vk) + len(name))
+		if err != nil {
+			return err
+		}
+		err = a.writeCell(off, append(vk, name...))
+		if err != nil {
+			return err
+		}
+		offsets = append(offsets, off)
+	}
+
+	if len(offsets) == 0 {
+		return nil
+	}
+	off, err := a.alloc(4 * len(offsets))
+	if err != nil {
+		return err
+	}
+	nk.valuesListOffset = off
+	nk.numberOfValues = uint32(len(offsets))
+	return a.writeCell(off, bytesFromOffsets(offsets))
+}
+
+// copySecurity returns the "sk" cell with the security descriptor of src,
+// creating it the first time the security descriptor is found
+func (b *hiveBuilder) copySecurity(src Key) (uint32, error) {
+	sk := newSecurityKey(src.rws, src.nk.binOffset, src.nk.securityKeyOffset)
+	err := sk.Read()
+	if err != nil {
+		return 0, err
+	}
+
+	if off, ok := b.security[string(sk.ntSecurityDescriptor)]; ok {
+		return off, retainSecurityKey(b.a, off)
+	}
+	off, err := newSecurityKeyCell(b.a, sk.ntSecurityDescriptor)
+	if err != nil {
+		return 0, err
+	}
+	b.security[string(sk.ntSecurityDescriptor)] = off
+
+	// insert the cell at the end of the list of "sk" cells
+	if b.firstSecurity == noOffset {
+		b.firstSecurity = off
+		return off, nil
+	}
+	link := make([]byte, 4)
+	last := make([]byte, 4)
+	err = b.a.readAt(b.firstSecurity+cellHeaderSize+4, last)
+	if err != nil {
+		return 0, err
+	}
+	for _, l := range []struct{ cell, field, value uint32 }{
+		{off, 4, binary.LittleEndian.Uint32(last)},
+		{off, 8, b.firstSecurity},
+		{binary.LittleEndian.Uint32(last), 8, off},
+		{b.firstSecurity, 4, off},
+	} {
+		binary.LittleEndian.PutUint32(link, l.value)
+		err = b.a.writeAt(l.cell+cellHeaderSize+l.field, link)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return off, nil
+}
+
+// subKeyList writes the subkey list of entries, sorted, splitting it
+// in leaves referenced by an index root if needed
+func (b *hiveBuilder) subKeyList(entries []subKeyEntry) (uint32, error) {
+	sort.Slice(entries, func(i, j int) bool { return compareKeyNames(entries[i].name, entries[j].name) < 0 })
+	if len(entries) <= maxLeafElements {
+		return writeSubKeyList(b.a, noOffset, subKeyList2Sig, entries)
+	}
+
+	var leaves []subKeyEntry
+	for len(entries) > 0 {
+		n := len(entries)
+		if n > maxLeafElements {
+			n = maxLeafElements
+		}
+		leaf, err := writeSubKeyList(b.a, noOffset, subKeyList2Sig, entries[:n])
+		if err != nil {
+			return 0, err
+		}
+		leaves = append(leaves, subKeyEntry{offset: leaf, name: entries[n-1].name})
+		entries = entries[n:]
+	}
+	return writeSubKeyList(b.a, noOffset, subKeyList4Sig, leaves)
+}
@@ -0,0 +1,118 @@
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testTree returns the values of every key of r by path
+func testTree(t *testing.T, r Registry) map[string][]Value {
+	root, err := r.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := map[string][]Value{}
+	err = root.walk("", func(path string, k Key) error {
+		values, err := k.ReadValues(-1)
+		tree[path] = values
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestRegistry_Compact(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	// leave free cells behind
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := r.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, _, err := root.CreateKey("Temporary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if _, _, err := k.CreateKey(fmt.Sprintf("Key%v", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		if err := k.DeleteKey(fmt.Sprintf("Key%v", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := k.SetBinaryValue("Big", bytes.Repeat([]byte{1, 2, 3}, 10000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var buf bytes.Buffer
+	before, after, err := r.Compact(&buf)
+	if err != nil {
+		t.Fatalf("Registry.Compact() error = %v", err)
+	}
+	if after != int64(buf.Len()) || after >= before {
+		t.Errorf("Registry.Compact() sizes = %v %v, written %v", before, after, buf.Len())
+	}
+
+	compacted := filepath.Join(filepath.Dir(file), "compacted.dat")
+	if err := ioutil.WriteFile(compacted, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	c, err := OpenFile(compacted, os.O_RDWR) // validates the cells
+	if err != nil {
+		t.Fatalf("OpenFile() of compacted hive error = %v", err)
+	}
+	defer c.Close()
+
+	want, got := testTree(t, r), testTree(t, c)
+	if len(got) != len(want) {
+		t.Errorf("compacted hive has %v keys, want %v", len(got), len(want))
+	}
+	for path, values := range want {
+		if !reflect.DeepEqual(got[path], values) {
+			t.Errorf("values of %v = %v, want %v", path, got[path], values)
+		}
+	}
+
+	// security descriptors are stored once, referenced by every key
+	refs := uint32(0)
+	root, _ = c.OpenKey("")
+	first := root.nk.securityKeyOffset
+	for off := first; ; {
+		sk := newSecurityKey(c.rws, hiveBinsOffset+cellHeaderSize, off)
+		if err := sk.Read(); err != nil {
+			t.Fatal(err)
+		}
+		refs += sk.referenceCount
+		off = sk.nextKeyOffset
+		if off == first {
+			break
+		}
+	}
+	if refs != uint32(len(got)) {
+		t.Errorf("security key references = %v, want %v", refs, len(got))
+	}
+}
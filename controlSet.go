@@ -0,0 +1,62 @@
+package registry
+
+import "strings"
+
+// currentControlSet is the link of SYSTEM hives to the control set in use,
+// created by Windows on boot and not stored in the hive
+const currentControlSet = "CurrentControlSet"
+
+// Values of the Select key of SYSTEM hives naming control sets
+const (
+	SelectCurrent       = "Current"
+	SelectDefault       = "Default"
+	SelectLastKnownGood = "LastKnownGood"
+)
+
+// ControlSet returns the name of the control set CurrentControlSet is
+// mapped to, like ControlSet001. It is read from the Select key, value
+// Current unless another one was chosen with WithControlSet.
+// If r has no Select key, like hives other than SYSTEM, ErrNotExist is returned.
+func (r Registry) ControlSet() (string, error) {
+	sel := r.controlSet
+	if sel == "" {
+		sel = SelectCurrent
+	}
+	return selectedControlSet(r, sel)
+}
+
+// WithControlSet returns r mapping CurrentControlSet to the control set
+// selected by value sel of the Select key, like SelectLastKnownGood.
+// Keys opened from the returned registry use that control set.
+func (r Registry) WithControlSet(sel string) (Registry, error) {
+	_, err := selectedControlSet(r, sel)
+	if err != nil {
+		return Registry{}, err
+	}
+	r.controlSet = sel
+	return r, nil
+}
+
+// controlSetPath maps the CurrentControlSet key at the start of path to
+// the selected control set, if k is the root key of a SYSTEM hive not
+// storing a CurrentControlSet key
+func (k Key) controlSetPath(path string) string {
+	if !k.nk.isRoot() {
+		return path
+	}
+	name, rest := path, ""
+	if i := strings.IndexByte(path, separator); i >= 0 {
+		name, rest = path[:i], path[i:]
+	}
+	if !strings.EqualFold(name, currentControlSet) {
+		return path
+	}
+	if _, err := k.openSubKey([]string{name}); err != ErrNotExist {
+		return path
+	}
+	set, err := k.registry.ControlSet()
+	if err != nil {
+		return path
+	}
+	return set + rest
+}
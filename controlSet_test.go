@@ -0,0 +1,101 @@
+package registry
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistry_ControlSet(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	r, err := Create(filepath.Join(filepath.Dir(file), "SYSTEM"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	root, err := r.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sel, _, err := root.CreateKey("Select")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, set := range []error{
+		sel.SetDWordValue(SelectCurrent, 2),
+		sel.SetDWordValue(SelectDefault, 2),
+		sel.SetDWordValue(SelectLastKnownGood, 1),
+	} {
+		if set != nil {
+			t.Fatal(set)
+		}
+	}
+	for _, path := range []string{`ControlSet001\Services\Old`, `ControlSet002\Services\New`} {
+		if _, _, err := root.CreateKey(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, err := r.ControlSet(); err != nil || got != "ControlSet002" {
+		t.Errorf("Registry.ControlSet() = %v, %v, want ControlSet002", got, err)
+	}
+	for _, path := range []string{`CurrentControlSet\Services\New`, `\currentcontrolset\Services\New\`} {
+		k, err := r.OpenKey(path)
+		if err != nil {
+			t.Fatalf("Registry.OpenKey(%v) error = %v", path, err)
+		}
+		if got, _ := k.Path(); got != `ControlSet002\Services\New` {
+			t.Errorf("Registry.OpenKey(%v) = %v", path, got)
+		}
+	}
+
+	k, _, err := root.CreateKey(`CurrentControlSet\Services\Created`)
+	if err != nil {
+		t.Fatalf("Key.CreateKey() error = %v", err)
+	}
+	if got, _ := k.Path(); got != `ControlSet002\Services\Created` {
+		t.Errorf("Key.CreateKey() = %v", got)
+	}
+
+	lkg, err := r.WithControlSet(SelectLastKnownGood)
+	if err != nil {
+		t.Fatalf("Registry.WithControlSet() error = %v", err)
+	}
+	if got, err := lkg.ControlSet(); err != nil || got != "ControlSet001" {
+		t.Errorf("Registry.ControlSet() = %v, %v, want ControlSet001", got, err)
+	}
+	if _, err := lkg.OpenKey(`CurrentControlSet\Services\Old`); err != nil {
+		t.Errorf("Registry.OpenKey() error = %v", err)
+	}
+	if _, err := r.OpenKey(`CurrentControlSet\Services\Old`); err != ErrNotExist {
+		t.Errorf("Registry.OpenKey() error = %v, want %v", err, ErrNotExist)
+	}
+	if _, err := r.WithControlSet("Failed"); err != ErrNotExist {
+		t.Errorf("Registry.WithControlSet() error = %v, want %v", err, ErrNotExist)
+	}
+
+	// only the root key has a CurrentControlSet
+	cs, err := r.OpenKey("ControlSet001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.OpenSubKey(`CurrentControlSet\Services`); err != ErrNotExist {
+		t.Errorf("Key.OpenSubKey() error = %v, want %v", err, ErrNotExist)
+	}
+}
+
+func TestRegistry_ControlSetNotSystem(t *testing.T) {
+	r, err := Open("testdata/NTUSER.DAT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if _, err := r.ControlSet(); err != ErrNotExist {
+		t.Errorf("Registry.ControlSet() error = %v, want %v", err, ErrNotExist)
+	}
+	if _, err := r.OpenKey(`CurrentControlSet\Services`); err != ErrNotExist {
+		t.Errorf("Registry.OpenKey() error = %v, want %v", err, ErrNotExist)
+	}
+}
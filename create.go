@@ -0,0 +1,234 @@
+package registry
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRootName = "ROOT"
+
+	fileNameSize = 64 // bytes of the file name stored in the base block
+)
+
+// CreateOptions are the options of a new registry
+type CreateOptions struct {
+	// Minor is the minor version of the hive format, from 3 to 6.
+	// Version 1.3 is supported since Windows NT 4.0 and version 1.5,
+	// the default, since Windows XP.
+	Minor uint32
+
+	// RootName is the name of the root key. The default is "ROOT".
+	RootName string
+
+	// SecurityDescriptor is the self-relative security descriptor of
+	// the root key, inherited by the keys created later. The default
+	// gives full access to SYSTEM and Administrators and read access to Users.
+	SecurityDescriptor []byte
+}
+
+// Create creates an empty registry file, truncating it if it exists,
+// with only the root key and opens it for writing.
+// opts may be nil to use the defaults.
+func Create(name string, opts *CreateOptions) (Registry, error) {
+	if opts == nil {
+		opts = &CreateOptions{}
+	}
+	minor := opts.Minor
+	if minor == 0 {
+		minor = 5
+	}
+	if minor < 3 || minor > 6 {
+		return Registry{}, ErrInvalidValue
+	}
+	rootName := opts.RootName
+	if rootName == "" {
+		rootName = defaultRootName
+	}
+	if len([]rune(rootName)) > maxKeyNameLength || strings.ContainsRune(rootName, separator) {
+		return Registry{}, ErrInvalidName
+	}
+	sd := opts.SecurityDescriptor
+	if sd == nil {
+		sd = defaultSecurityDescriptor()
+	}
+
+	fp, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return Registry{}, err
+	}
+	f, err := newHiveFile(fp, name)
+	if err == nil {
+		err = writeEmptyHive(f, name, minor, rootName, sd)
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		fp.Close()
+		return Registry{}, err
+	}
+
+	r, err := open(f, true)
+	if err != nil {
+		fp.Close()
+		return Registry{}, err
+	}
+	r.fp = fp
+	r.file = f
+	err = r.Commit()
+	if err != nil {
+		fp.Close()
+		return Registry{}, err
+	}
+	return r, nil
+}
+
+// writeEmptyHive writes the base block and the first hive bin with
+// the security descriptor and the root key
+func writeEmptyHive(f *hiveFile, name string, minor uint32, rootName string, sd []byte) error {
+	// the base block holds the end of the file path
+	h, err := newBaseBlock(f, minor, utf16LEFromString(strings.Replace(name, "/", `\`, -1)))
+	if err != nil {
+		return err
+	}
+
+	a := &cellAllocator{rws: f, header: h}
+	err = a.grow(binAlignment - hiveBinsDataStart)
+	if err != nil {
+		return err
+	}
+
+	// the root key is the first cell, as in the hives created by Windows
+	root, err := newNamedKeyCell(a, rootName, noOffset, noOffset, nk_KEY_HIVE_ENTRY|nk_KEY_NO_DELETE)
+	if err != nil {
+		return err
+	}
+	sk, err := newSecurityKeyCell(a, sd)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, sk)
+	err = a.writeCell(root+44, b)
+	if err != nil {
+		return err
+	}
+
+	h.rootOffset = root
+	return h.Write()
+}
+
+// newBaseBlock writes the base block of a hive without hive bins to rws.
+// fileName is the UTF-16 path of the file, only its end is kept.
+func newBaseBlock(rws io.ReadWriteSeeker, minor uint32, fileName []byte) (*header, error) {
+	h := newHeader(rws)
+	h.buf = make([]byte, hiveBinsOffset)
+	copy(h.buf, registrySig)
+	h.primarySequence = 1
+	h.secondarySequence = 1
+	h.lastModification = filetime(time.Now())
+	h.major = 1
+	h.minor = minor
+	binary.LittleEndian.PutUint32(h.buf[20:24], h.major)
+	binary.LittleEndian.PutUint32(h.buf[24:28], h.minor)
+	binary.LittleEndian.PutUint32(h.buf[32:36], 1) // file format, direct memory load
+	binary.LittleEndian.PutUint32(h.buf[44:48], 1) // clustering factor
+
+	if len(fileName) > fileNameSize-2 {
+		fileName = fileName[len(fileName)-fileNameSize+2:]
+	}
+	copy(h.buf[48:48+fileNameSize], fileName)
+
+	return h, h.Write()
+}
+
+// newSecurityKeyCell allocates a "sk" cell for sd, the only one of the list of "sk" cells
+func newSecurityKeyCell(a *cellAllocator, sd []byte) (uint32, error) {
+	b := make([]byte, 20+len(sd))
+	sk, err := a.alloc(len(b))
+	if err != nil {
+		return 0, err
+	}
+
+	copy(b, securityKeySig)
+	binary.LittleEndian.PutUint32(b[4:8], sk)
+	binary.LittleEndian.PutUint32(b[8:12], sk)
+	binary.LittleEndian.PutUint32(b[12:16], 1)
+	binary.LittleEndian.PutUint32(b[16:20], uint32(len(sd)))
+	copy(b[20:], sd)
+	return sk, a.writeCell(sk, b)
+}
+
+// well known security identifiers, as revision 1 SIDs in binary form
+var (
+	sidSystem         = sid(5, 18)
+	sidAdministrators = sid(5, 32, 544)
+	sidUsers          = sid(5, 32, 545)
+)
+
+const (
+	keyAllAccess = 0xf003f
+	keyRead      = 0x20019
+
+	containerInheritAce = 0x02
+
+	seDaclPresent   = 0x0004
+	seDaclProtected = 0x1000
+	seSelfRelative  = 0x8000
+)
+
+// sid returns the binary form of the SID S-1-authority-subAuthorities...
+func sid(authority uint64, subAuthorities ...uint32) []byte {
+	b := make([]byte, 8+4*len(subAuthorities))
+	b[0] = 1
+	b[1] = byte(len(subAuthorities))
+	for i := 0; i < 6; i++ {
+		b[7-i] = byte(authority >> (8 * uint(i)))
+	}
+	for i, s := range subAuthorities {
+		binary.LittleEndian.PutUint32(b[8+4*i:], s)
+	}
+	return b
+}
+
+// defaultSecurityDescriptor returns a self-relative security descriptor
+// owned by Administrators, giving full access to SYSTEM and Administrators
+// and read access to Users, inherited by subkeys.
+func defaultSecurityDescriptor() []byte {
+	aces := []struct {
+		mask uint32
+		sid  []byte
+	}{
+		{keyAllAccess, sidSystem},
+		{keyAllAccess, sidAdministrators},
+		{keyRead, sidUsers},
+	}
+
+	acl := make([]byte, 8)
+	acl[0] = 2 // ACL revision
+	for _, ace := range aces {
+		b := make([]byte, 8+len(ace.sid))
+		b[0] = 0 // ACCESS_ALLOWED_ACE_TYPE
+		b[1] = containerInheritAce
+		binary.LittleEndian.PutUint16(b[2:4], uint16(len(b)))
+		binary.LittleEndian.PutUint32(b[4:8], ace.mask)
+		copy(b[8:], ace.sid)
+		acl = append(acl, b...)
+	}
+	binary.LittleEndian.PutUint16(acl[2:4], uint16(len(acl)))
+	binary.LittleEndian.PutUint16(acl[4:6], uint16(len(aces)))
+
+	sd := make([]byte, 20)
+	sd[0] = 1 // revision
+	binary.LittleEndian.PutUint16(sd[2:4], seSelfRelative|seDaclProtected|seDaclPresent)
+	binary.LittleEndian.PutUint32(sd[4:8], uint32(len(sd)+len(acl)))                         // owner
+	binary.LittleEndian.PutUint32(sd[8:12], uint32(len(sd)+len(acl)+len(sidAdministrators))) // group
+	binary.LittleEndian.PutUint32(sd[16:20], uint32(len(sd)))                                // DACL
+	sd = append(sd, acl...)
+	sd = append(sd, sidAdministrators...)
+	return append(sd, sidSystem...)
+}
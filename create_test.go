@@ -0,0 +1,100 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		opts    *CreateOptions
+		root    string
+		list    string
+		wantErr error
+	}{
+		{"default", nil, "ROOT", subKeyList2Sig, nil},
+		{"v1.3", &CreateOptions{Minor: 3, RootName: "App"}, "App", subKeyList1Sig, nil},
+		{"bad version", &CreateOptions{Minor: 7}, "", "", ErrInvalidValue},
+		{"bad root", &CreateOptions{RootName: `a\b`}, "", "", ErrInvalidName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, tt.name+".dat")
+			r, err := Create(file, tt.opts)
+			if err != tt.wantErr {
+				t.Fatalf("Create() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			root, err := r.OpenKey("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			k, _, err := root.CreateKey(`Software\Vendor`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := k.SetStringValue("Version", "1.0"); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err = Open(file)
+			if err != nil {
+				t.Fatalf("Open() of created registry error = %v", err)
+			}
+			defer r.Close()
+
+			root, err = r.OpenKey("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if root.Name() != tt.root || root.nk.flags != nk_KEY_HIVE_ENTRY|nk_KEY_NO_DELETE|nk_KEY_COMP_NAME {
+				t.Errorf("root = %v %#x", root.Name(), root.nk.flags)
+			}
+			if sig, _, err := readSubKeyList(&cellAllocator{rws: r.rws}, root.nk.subKeysListOffset); sig != tt.list || err != nil {
+				t.Errorf("subkey list = %v %v, want %v", sig, err, tt.list)
+			}
+			if s, err := r.OpenKey(`Software\Vendor`); err != nil {
+				t.Errorf("OpenKey() error = %v", err)
+			} else if v, _, err := s.GetStringValue("Version"); v != "1.0" || err != nil {
+				t.Errorf("Version = %v %v", v, err)
+			}
+
+			sk := newSecurityKey(r.rws, hiveBinsOffset+cellHeaderSize, root.nk.securityKeyOffset)
+			if err := sk.Read(); err != nil {
+				t.Fatalf("securityKey.Read() error = %v", err)
+			}
+			if sk.referenceCount != 3 || !bytes.Equal(sk.ntSecurityDescriptor, defaultSecurityDescriptor()) {
+				t.Errorf("security key = %v %x", sk.referenceCount, sk.ntSecurityDescriptor)
+			}
+		})
+	}
+}
+
+func TestDefaultSecurityDescriptor(t *testing.T) {
+	sd := defaultSecurityDescriptor()
+	want := "0100" + "0490" + "60000000" + "70000000" + "00000000" + "14000000" +
+		"02004c00" + "03000000" +
+		"00021400" + "3f000f00" + "010100000000000512000000" +
+		"00021800" + "3f000f00" + "01020000000000052000000020020000" +
+		"00021800" + "19000200" + "01020000000000052000000021020000" +
+		"01020000000000052000000020020000" + "010100000000000512000000"
+	if got := hex.EncodeToString(sd); got != want {
+		t.Errorf("defaultSecurityDescriptor() = %v, want %v", got, want)
+	}
+}
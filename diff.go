@@ -0,0 +1,338 @@
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DiffKind is the kind of a difference found by Diff
+type DiffKind int
+
+// Kinds of differences
+const (
+	DiffAdded    DiffKind = iota + 1 // only in b
+	DiffRemoved                      // only in a
+	DiffModified                     // in both, with changes
+)
+
+func (d DiffKind) String() string {
+	switch d {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffModified:
+		return "modified"
+	}
+	return ""
+}
+
+// KeyDiff is a key added, removed or modified. The values of added
+// and removed keys are reported as added and removed values.
+type KeyDiff struct {
+	Kind DiffKind
+	Path string // path from the compared keys
+
+	// last write times, zero for the missing key
+	ATime, BTime time.Time
+
+	Values []ValueDiff
+}
+
+// ValueDiff is a value added, removed or whose type or data changed
+type ValueDiff struct {
+	Kind DiffKind
+	Name string // "(default)" for the default value, like Value.Name
+	A, B *Value // nil for the missing value
+}
+
+// Diff returns the differences between key a and key b and their subkeys,
+// in the order of a depth first walk of the keys sorted by name.
+// A key is modified when its values or its last write time changed.
+func Diff(a, b Key) ([]KeyDiff, error) {
+	var diffs []KeyDiff
+	err := WalkDiff(a, b, func(d KeyDiff) error {
+		diffs = append(diffs, d)
+		return nil
+	})
+	return diffs, err
+}
+
+// WalkDiff calls fn for each difference between key a and key b and their
+// subkeys, like Diff, without keeping them in memory. If fn returns an
+// error, the walk stops and the error is returned.
+func WalkDiff(a, b Key, fn func(KeyDiff) error) error {
+	return diffKeys(a, b, "", fn)
+}
+
+// diffKeys reports the differences between keys a and b, known as path
+func diffKeys(a, b Key, path string, fn func(KeyDiff) error) error {
+	a = newKey(a.registry, a.rws, a.nk) // keep the cursors of a and b
+	b = newKey(b.registry, b.rws, b.nk)
+
+	av, err := sortedValues(a)
+	if err != nil {
+		return err
+	}
+	bv, err := sortedValues(b)
+	if err != nil {
+		return err
+	}
+	d := KeyDiff{Kind: DiffModified, Path: path, ATime: date(a.nk.lastModified), BTime: date(b.nk.lastModified)}
+	for i, j := 0, 0; i < len(av) || j < len(bv); {
+		c := compareNames(av, i, bv, j)
+		switch {
+		case c < 0:
+			d.Values = append(d.Values, ValueDiff{Kind: DiffRemoved, Name: av[i].Name, A: &av[i]})
+			i++
+		case c > 0:
+			d.Values = append(d.Values, ValueDiff{Kind: DiffAdded, Name: bv[j].Name, B: &bv[j]})
+			j++
+		default:
+			if av[i].Type != bv[j].Type || !bytes.Equal(av[i].raw, bv[j].raw) {
+				d.Values = append(d.Values, ValueDiff{Kind: DiffModified, Name: bv[j].Name, A: &av[i], B: &bv[j]})
+			}
+			i++
+			j++
+		}
+	}
+	if len(d.Values) > 0 || a.nk.lastModified != b.nk.lastModified {
+		err = fn(d)
+		if err != nil {
+			return err
+		}
+	}
+
+	as, err := sortedSubKeys(a)
+	if err != nil {
+		return err
+	}
+	bs, err := sortedSubKeys(b)
+	if err != nil {
+		return err
+	}
+	for i, j := 0, 0; i < len(as) || j < len(bs); {
+		var c int
+		switch {
+		case i == len(as):
+			c = 1
+		case j == len(bs):
+			c = -1
+		default:
+			c = compareKeyNames(as[i].Name(), bs[j].Name())
+		}
+
+		switch {
+		case c < 0:
+			err = diffKey(as[i], joinPath(path, as[i].Name()), DiffRemoved, fn)
+			i++
+		case c > 0:
+			err = diffKey(bs[j], joinPath(path, bs[j].Name()), DiffAdded, fn)
+			j++
+		default:
+			err = diffKeys(as[i], bs[j], joinPath(path, bs[j].Name()), fn)
+			i++
+			j++
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffKey reports key k, known as path, and its subkeys as added or removed
+func diffKey(k Key, path string, kind DiffKind, fn func(KeyDiff) error) error {
+	k = newKey(k.registry, k.rws, k.nk) // keep the cursor of k
+	values, err := sortedValues(k)
+	if err != nil {
+		return err
+	}
+
+	d := KeyDiff{Kind: kind, Path: path}
+	if kind == DiffAdded {
+		d.BTime = date(k.nk.lastModified)
+	} else {
+		d.ATime = date(k.nk.lastModified)
+	}
+	for i := range values {
+		v := ValueDiff{Kind: kind, Name: values[i].Name}
+		if kind == DiffAdded {
+			v.B = &values[i]
+		} else {
+			v.A = &values[i]
+		}
+		d.Values = append(d.Values, v)
+	}
+	err = fn(d)
+	if err != nil {
+		return err
+	}
+
+	subs, err := sortedSubKeys(k)
+	if err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		err = diffKey(sub, joinPath(path, sub.Name()), kind, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedValues returns the values of k sorted by name
+func sortedValues(k Key) ([]Value, error) {
+	values, err := k.ReadValues(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(values, func(i, j int) bool { return compareKeyNames(values[i].Name, values[j].Name) < 0 })
+	return values, nil
+}
+
+// sortedSubKeys returns the subkeys of k sorted by name, reading
+// the subkey list once instead of opening each subkey by name
+func sortedSubKeys(k Key) ([]Key, error) {
+	if k.nk.numberOfSubKeys == 0 {
+		return nil, nil
+	}
+	list, err := k.subkeys()
+	if err != nil {
+		return nil, err
+	}
+	els, err := list.allElements()
+	if err != nil {
+		return nil, err
+	}
+
+	var subs []Key
+	for _, el := range els {
+		if el.namedKey != nil {
+			subs = append(subs, newKey(k.registry, k.rws, el.namedKey))
+		}
+	}
+	sort.SliceStable(subs, func(i, j int) bool { return compareKeyNames(subs[i].Name(), subs[j].Name()) < 0 })
+	return subs, nil
+}
+
+// compareNames compares the names of values a[i] and b[j],
+// a missing value being greater than any other
+func compareNames(a []Value, i int, b []Value, j int) int {
+	switch {
+	case i == len(a):
+		return 1
+	case j == len(b):
+		return -1
+	}
+	return compareKeyNames(a[i].Name, b[j].Name)
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + string(separator) + name
+}
+
+// WriteUnifiedDiff writes diffs as text, in the style of a unified diff:
+// keys and values are written as in .reg files, prefixed by '-' when
+// removed, '+' when added and ' ' for the keys containing changes.
+// Last write times are written as comments. aName and bName are
+// the names of the compared keys written in the header.
+func WriteUnifiedDiff(w io.Writer, aName, bName string, diffs []KeyDiff) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "--- %s\n+++ %s\n", aName, bName)
+
+	line := func(prefix, s string) {
+		// wrapped hex data continues on the next lines
+		for _, l := range strings.Split(s, "\r\n") {
+			bw.WriteString(prefix + l + "\n")
+		}
+	}
+	for _, d := range diffs {
+		key := `[\` + d.Path + `]`
+		switch d.Kind {
+		case DiffAdded:
+			line("+", key)
+		case DiffRemoved:
+			line("-", key)
+		default:
+			line(" ", key)
+			if !d.ATime.Equal(d.BTime) {
+				line("-", "; last write "+d.ATime.Format(time.RFC3339Nano))
+				line("+", "; last write "+d.BTime.Format(time.RFC3339Nano))
+			}
+		}
+		for _, v := range d.Values {
+			if v.A != nil {
+				line("-", regValueLine(*v.A, true))
+			}
+			if v.B != nil {
+				line("+", regValueLine(*v.B, true))
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteRegPatch writes diffs as a .reg file turning the first compared
+// key into the second one. opts.Root is written before the paths of the
+// keys, without a root the paths are relative to the compared keys.
+// Changes of last write times only are not written.
+func WriteRegPatch(w io.Writer, diffs []KeyDiff, opts *RegOptions) error {
+	if opts == nil {
+		opts = &RegOptions{}
+	}
+	rw := &regWriter{w: w, unicode: opts.Version != RegVersion4}
+	root := strings.TrimRight(opts.Root, string(separator))
+
+	if rw.unicode {
+		rw.writeRaw([]byte{0xff, 0xfe})
+		rw.writeLine(regHeader5)
+	} else {
+		rw.writeLine(regHeader4)
+	}
+	rw.writeLine("")
+
+	removed := "" // path of the last removed key, its subkeys are removed with it
+	for _, d := range diffs {
+		path := d.Path
+		if root != "" {
+			path = joinPath(root, path)
+		}
+		if d.Kind == DiffRemoved {
+			if removed != "" && strings.HasPrefix(strings.ToUpper(d.Path), strings.ToUpper(removed)+string(separator)) {
+				continue
+			}
+			removed = d.Path
+			rw.writeLine("[-" + path + "]")
+			rw.writeLine("")
+			continue
+		}
+		if d.Kind == DiffModified && len(d.Values) == 0 {
+			continue
+		}
+
+		rw.writeLine("[" + path + "]")
+		for _, v := range d.Values {
+			if v.B == nil {
+				name := "@"
+				if v.Name != defaultValueName {
+					name = `"` + escapeRegString(v.Name) + `"`
+				}
+				rw.writeLine(name + "=-")
+				continue
+			}
+			rw.exportValue(*v.B)
+		}
+		rw.writeLine("")
+	}
+	return rw.err
+}
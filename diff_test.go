@@ -0,0 +1,191 @@
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	fileB, cleanupB := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanupB()
+	fileP, cleanupP := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanupP()
+
+	a, err := Open("testdata/NTUSER.DAT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := OpenFile(fileB, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	ka, err := a.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := b.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs, err := Diff(ka, kb); err != nil || len(diffs) != 0 {
+		t.Fatalf("Diff() of the same hive = %v, %v", diffs, err)
+	}
+
+	// change b
+	added, _, err := kb.CreateKey(`Added\Sub`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desktop, err := kb.OpenSubKey(`Control Panel\Desktop`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, set := range []error{
+		added.SetDWordValue("DWord", 1),
+		desktop.SetStringValue("WallPaper", `C:\evil.bmp`),
+		desktop.SetStringValue("New", "new"),
+		desktop.DeleteValue("WheelScrollLines"),
+		kb.deleteTree(`SOFTWARE\Microsoft\Windows`),
+	} {
+		if set != nil {
+			t.Fatal(set)
+		}
+	}
+
+	diffs, err := Diff(ka, kb)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	kinds := map[string]DiffKind{}
+	var desktopDiff *KeyDiff
+	for i, d := range diffs {
+		kinds[d.Path] = d.Kind
+		if d.Path == `Control Panel\Desktop` {
+			desktopDiff = &diffs[i]
+		}
+	}
+	for path, want := range map[string]DiffKind{
+		`Added`:                                   DiffAdded,
+		`Added\Sub`:                               DiffAdded,
+		`Control Panel\Desktop`:                   DiffModified,
+		`SOFTWARE\Microsoft\Windows`:              DiffRemoved,
+		`SOFTWARE\Microsoft\Windows\DWM`:          DiffRemoved,
+		`SOFTWARE\Microsoft\InputPersonalization`: 0,
+	} {
+		if kinds[path] != want {
+			t.Errorf("Diff() kind of %v = %v, want %v", path, kinds[path], want)
+		}
+	}
+	if desktopDiff == nil {
+		t.Fatal("Diff() did not report Control Panel\\Desktop")
+	}
+	got := map[string]DiffKind{}
+	for _, v := range desktopDiff.Values {
+		got[v.Name] = v.Kind
+	}
+	want := map[string]DiffKind{"New": DiffAdded, "WallPaper": DiffModified, "WheelScrollLines": DiffRemoved}
+	if len(got) != len(want) {
+		t.Errorf("Diff() values of Control Panel\\Desktop = %v, want %v", got, want)
+	}
+	for name, kind := range want {
+		if got[name] != kind {
+			t.Errorf("Diff() value %v = %v, want %v", name, got[name], kind)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteUnifiedDiff(&buf, "a/NTUSER.DAT", "b/NTUSER.DAT", diffs); err != nil {
+		t.Fatalf("WriteUnifiedDiff() error = %v", err)
+	}
+	text := buf.String()
+	for _, want := range []string{
+		"--- a/NTUSER.DAT\n+++ b/NTUSER.DAT\n",
+		"+[\\Added]\n",
+		"+[\\Added\\Sub]\n+\"DWord\"=dword:00000001\n",
+		" [\\Control Panel\\Desktop]\n-; last write ",
+		"+\"New\"=\"new\"\n",
+		"+\"WallPaper\"=\"C:\\\\evil.bmp\"\n",
+		"-[\\SOFTWARE\\Microsoft\\Windows]\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("WriteUnifiedDiff() = %v, want it to contain %q", text, want)
+		}
+	}
+
+	// the .reg patch turns a into b
+	buf.Reset()
+	if err := WriteRegPatch(&buf, diffs, &RegOptions{Root: `HKEY_CURRENT_USER`}); err != nil {
+		t.Fatalf("WriteRegPatch() error = %v", err)
+	}
+	if n := strings.Count(string(latin1FromUTF16LE(buf.Bytes()[2:])), "[-"); n != 1 {
+		t.Errorf("WriteRegPatch() removes %v keys, want 1", n)
+	}
+	f, err := ParseReg(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := OpenFile(fileP, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.ImportReg(f, `HKEY_CURRENT_USER`); err != nil {
+		t.Fatal(err)
+	}
+	kp, err := p.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffs, err = Diff(kp, kb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range diffs {
+		if d.Kind != DiffModified || len(d.Values) > 0 {
+			t.Errorf("Diff() of the patched registry = %+v", d)
+		}
+	}
+}
+
+func BenchmarkDiff(b *testing.B) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// keys with many subkeys, every tenth one missing from the second hive
+	var keys [2]Key
+	for i := range keys {
+		r := testHive(b, filepath.Join(dir, fmt.Sprint(i)), func(k Key) error {
+			for n := 0; n < 2000; n++ {
+				if i == 1 && n%10 == 0 {
+					continue
+				}
+				if _, _, err := k.CreateKey(fmt.Sprintf(`Many\Sub%04d`, n)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		defer r.Close()
+		if keys[i], err = r.OpenKey(""); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := Diff(keys[0], keys[1]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Environment is a set of environment variables used to expand
+// REG_EXPAND_SZ values of an offline system. Variable names are case insensitive.
+//
+// The variables are loaded from the hives, as Windows does when creating
+// a user session: LoadSystem and LoadSoftware for the machine variables
+// and LoadUser for the variables of the user. A Path defined by the user
+// is appended to the machine Path, every other user variable overrides
+// the machine one.
+type Environment struct {
+	vars  map[string]string // upper case name to value
+	names map[string]string // upper case name to name as defined
+}
+
+// NewEnvironment returns an empty environment
+func NewEnvironment() *Environment {
+	return &Environment{
+		vars:  map[string]string{},
+		names: map[string]string{},
+	}
+}
+
+// Set defines variable name. value may reference other variables
+func (e *Environment) Set(name, value string) {
+	u := strings.ToUpper(name)
+	e.vars[u] = value
+	e.names[u] = name
+}
+
+// Get returns the expanded value of variable name
+func (e *Environment) Get(name string) (string, bool) {
+	return e.get(name, map[string]bool{})
+}
+
+// get returns the value of variable name expanded. Like Windows, the
+// variables being expanded, in expanding, are left untouched when
+// referenced again.
+func (e *Environment) get(name string, expanding map[string]bool) (string, bool) {
+	u := strings.ToUpper(name)
+	v, ok := e.vars[u]
+	if !ok || expanding[u] {
+		return "", false
+	}
+	expanding[u] = true
+	v = e.expand(v, expanding)
+	delete(expanding, u)
+	return v, true
+}
+
+// Names returns the names of the defined variables
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.names))
+	for _, n := range e.names {
+		names = append(names, n)
+	}
+	return names
+}
+
+// ExpandString expands the %VAR% references of value.
+// Names are case insensitive and undefined variables are left untouched,
+// like the Windows ExpandEnvironmentStrings function does.
+func (e *Environment) ExpandString(value string) (string, error) {
+	return e.expand(value, map[string]bool{}), nil
+}
+
+func (e *Environment) expand(value string, expanding map[string]bool) string {
+	return expandWith(value, func(name string) (string, bool) {
+		return e.get(name, expanding)
+	})
+}
+
+// ExpandString expands the %VAR% references of value using the
+// environment of the running process, like the function of golang's
+// sys/windows/registry module. To expand values of an offline
+// system use Environment.ExpandString.
+func ExpandString(value string) (string, error) {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		i := strings.IndexByte(kv, '=')
+		if i > 0 {
+			env[strings.ToUpper(kv[:i])] = kv[i+1:]
+		}
+	}
+	return expandWith(value, func(name string) (string, bool) {
+		v, ok := env[strings.ToUpper(name)]
+		return v, ok
+	}), nil
+}
+
+func expandWith(value string, lookup func(string) (string, bool)) string {
+	var sb strings.Builder
+	for {
+		i := strings.IndexByte(value, '%')
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(value[i+1:], '%')
+		if j < 0 {
+			break
+		}
+		j += i + 1
+
+		v, ok := lookup(value[i+1 : j])
+		if !ok {
+			// keep the first '%' and retry from the second one
+			sb.WriteString(value[:j])
+			value = value[j:]
+			continue
+		}
+		sb.WriteString(value[:i])
+		sb.WriteString(v)
+		value = value[j+1:]
+	}
+	sb.WriteString(value)
+	return sb.String()
+}
+
+// LoadSystem loads the machine variables of a SYSTEM hive, stored in
+// ControlSet00N\Control\Session Manager\Environment of the control set
+// returned by Registry.ControlSet
+func (e *Environment) LoadSystem(system Registry) error {
+	set, err := system.ControlSet()
+	if err != nil {
+		return err
+	}
+	k, err := system.OpenKey(set + `\Control\Session Manager\Environment`)
+	if err != nil {
+		return err
+	}
+	return e.loadKey(k, false)
+}
+
+// LoadSoftware loads the variables Windows derives from a SOFTWARE hive:
+// SystemRoot, windir, SystemDrive, the program files directories and the
+// profile directories of ProfileList. If sid is not empty, the profile of
+// that user defines USERPROFILE, HOMEDRIVE, HOMEPATH, USERNAME, APPDATA
+// and LOCALAPPDATA.
+func (e *Environment) LoadSoftware(software Registry, sid string) error {
+	k, err := software.OpenKey(`Microsoft\Windows NT\CurrentVersion`)
+	if err != nil {
+		return err
+	}
+	if root, _, err := k.GetStringValue("SystemRoot"); err == nil {
+		e.Set("SystemRoot", root)
+		e.Set("windir", "%SystemRoot%")
+		if len(root) >= 2 && root[1] == ':' {
+			e.Set("SystemDrive", root[:2])
+		}
+	}
+
+	k, err = software.OpenKey(`Microsoft\Windows\CurrentVersion`)
+	if err == nil {
+		for _, v := range [][2]string{
+			{"ProgramFilesDir", "ProgramFiles"},
+			{"ProgramFilesDir (x86)", "ProgramFiles(x86)"},
+			{"ProgramW6432Dir", "ProgramW6432"},
+			{"CommonFilesDir", "CommonProgramFiles"},
+			{"CommonFilesDir (x86)", "CommonProgramFiles(x86)"},
+			{"CommonW6432Dir", "CommonProgramW6432"},
+		} {
+			if s, _, err := k.GetStringValue(v[0]); err == nil {
+				e.Set(v[1], s)
+			}
+		}
+	}
+
+	profiles, err := software.OpenKey(`Microsoft\Windows NT\CurrentVersion\ProfileList`)
+	if err != nil {
+		return err
+	}
+	for _, v := range [][2]string{
+		{"ProgramData", "ProgramData"},
+		{"ProgramData", "ALLUSERSPROFILE"},
+		{"Public", "PUBLIC"},
+	} {
+		if s, _, err := profiles.GetStringValue(v[0]); err == nil {
+			e.Set(v[1], s)
+		}
+	}
+
+	if sid == "" {
+		return nil
+	}
+	profile, err := profiles.OpenSubKey(sid)
+	if err != nil {
+		return err
+	}
+	dir, _, err := profile.GetStringValue("ProfileImagePath")
+	if err != nil {
+		return err
+	}
+	e.Set("USERPROFILE", dir)
+	e.Set("APPDATA", `%USERPROFILE%\AppData\Roaming`)
+	e.Set("LOCALAPPDATA", `%USERPROFILE%\AppData\Local`)
+
+	dir, _ = e.Get("USERPROFILE")
+	if len(dir) >= 2 && dir[1] == ':' {
+		e.Set("HOMEDRIVE", dir[:2])
+		e.Set("HOMEPATH", dir[2:])
+	}
+	e.Set("USERNAME", path.Base(strings.Replace(dir, string(separator), "/", -1)))
+	return nil
+}
+
+// LoadUser loads the user variables of a NTUSER.DAT hive, stored in Environment
+func (e *Environment) LoadUser(ntuser Registry) error {
+	k, err := ntuser.OpenKey("Environment")
+	if err != nil {
+		return err
+	}
+	return e.loadKey(k, true)
+}
+
+func (e *Environment) loadKey(k Key, user bool) error {
+	values, err := k.ReadValues(-1)
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		if v.Type != REG_SZ && v.Type != REG_EXPAND_SZ {
+			continue
+		}
+		s := v.String()
+		if user && strings.EqualFold(v.Name, "Path") {
+			if p, ok := e.vars["PATH"]; ok && p != "" {
+				s = strings.TrimSuffix(p, ";") + ";" + s
+			}
+		}
+		e.Set(v.Name, s)
+	}
+	return nil
+}
+
+// selectedControlSet returns the name of the control set selected by
+// value sel ("Current", "Default" or "LastKnownGood") of the Select key
+func selectedControlSet(system Registry, sel string) (string, error) {
+	k, err := system.OpenKey("Select")
+	if err != nil {
+		return "", err
+	}
+	n, _, err := k.GetIntegerValue(sel)
+	if err != nil {
+		return "", err
+	}
+	return controlSetName(n), nil
+}
+
+func controlSetName(n uint64) string {
+	return fmt.Sprintf("ControlSet%03d", n)
+}
+
+// GetExpandedStringValue retrieves the string value for the specified
+// value name associated with an open key k, expanding the environment
+// variables of REG_EXPAND_SZ values with env. It also returns the value's type.
+// If env is nil, the value is expanded with an empty environment, leaving
+// the variables untouched.
+// If value does not exist, GetExpandedStringValue returns ErrNotExist.
+// If value is not REG_SZ or REG_EXPAND_SZ, it will return the correct value
+// type and ErrUnexpectedType.
+func (k Key) GetExpandedStringValue(name string, env *Environment) (val string, valtype uint32, err error) {
+	val, valtype, err = k.GetStringValue(name)
+	if err != nil || valtype != REG_EXPAND_SZ {
+		return
+	}
+	if env == nil {
+		env = NewEnvironment()
+	}
+	val, err = env.ExpandString(val)
+	return
+}
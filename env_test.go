@@ -0,0 +1,134 @@
+package registry
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestEnvironment_ExpandString(t *testing.T) {
+	env := NewEnvironment()
+	env.Set("SystemRoot", `C:\Windows`)
+	env.Set("windir", "%SystemRoot%")
+	env.Set("ComSpec", `%SYSTEMROOT%\system32\cmd.exe`)
+	env.Set("loop", "%LOOP%")
+	env.Set("A", "%B%%B%")
+	env.Set("B", "%A%%A%")
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: `%systemroot%\system32`, want: `C:\Windows\system32`},
+		{value: `%WINDIR%;%ComSpec%`, want: `C:\Windows;C:\Windows\system32\cmd.exe`},
+		{value: `100%`, want: `100%`},
+		{value: `%undefined%\%windir%`, want: `%undefined%\C:\Windows`},
+		{value: `50% %windir%`, want: `50% C:\Windows`},
+		{value: `%%windir%`, want: `%C:\Windows`},
+		{value: `%loop%`, want: `%LOOP%`},
+		{value: `%a%`, want: `%A%%A%%A%%A%`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := env.ExpandString(tt.value)
+			if err != nil || got != tt.want {
+				t.Errorf("Environment.ExpandString() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestKey_GetExpandedStringValue(t *testing.T) {
+	r, err := Open("testdata/NTUSER.DAT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	env := NewEnvironment()
+	env.Set("Path", `C:\Windows\system32`)
+	env.Set("UserProfile", `C:\Users\test`)
+	if err := env.LoadUser(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := env.Get("PATH"); got != `C:\Windows\system32;C:\Users\test\AppData\Local\Microsoft\WindowsApps;` {
+		t.Errorf("Environment.Get(PATH) = %v", got)
+	}
+	if got, _ := env.Get("temp"); got != `C:\Users\test\AppData\Local\Temp` {
+		t.Errorf("Environment.Get(temp) = %v", got)
+	}
+
+	k, err := r.OpenKey("Environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, valtype, err := k.GetExpandedStringValue("TMP", env)
+	if err != nil || valtype != REG_EXPAND_SZ || got != `C:\Users\test\AppData\Local\Temp` {
+		t.Errorf("Key.GetExpandedStringValue() = %v, %v, %v", got, valtype, err)
+	}
+}
+
+func TestKey_GetExpandedStringValueNilEnv(t *testing.T) {
+	r, err := Open("testdata/NTUSER.DAT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	k, err := r.OpenKey("Environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, valtype, err := k.GetExpandedStringValue("TMP", nil)
+	if err != nil || valtype != REG_EXPAND_SZ || got != `%USERPROFILE%\AppData\Local\Temp` {
+		t.Errorf("Key.GetExpandedStringValue() = %v, %v, %v", got, valtype, err)
+	}
+}
+
+func TestEnvironment_LoadSystem(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	system := testHive(t, filepath.Join(filepath.Dir(file), "SYSTEM"), func(k Key) error {
+		sel, _, err := k.CreateKey("Select")
+		if err != nil {
+			return err
+		}
+		if err = sel.SetDWordValue("Current", 2); err != nil {
+			return err
+		}
+		for set, value := range map[string]string{"ControlSet001": `C:\Old`, "ControlSet002": `C:\Windows\system32`} {
+			env, _, err := k.CreateKey(set + `\Control\Session Manager\Environment`)
+			if err != nil {
+				return err
+			}
+			if err = env.SetExpandStringValue("Path", value); err != nil {
+				return err
+			}
+			if err = env.SetStringValue("OS", "Windows_NT"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	defer system.Close()
+
+	env := NewEnvironment()
+	if err := env.LoadSystem(system); err != nil {
+		t.Fatalf("Environment.LoadSystem() error = %v", err)
+	}
+	if got, _ := env.Get("PATH"); got != `C:\Windows\system32` {
+		t.Errorf("Environment.Get(PATH) = %v, want the path of the current control set", got)
+	}
+	if got, _ := env.Get("os"); got != "Windows_NT" {
+		t.Errorf("Environment.Get(os) = %v", got)
+	}
+
+	ntuser, err := Open("testdata/NTUSER.DAT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ntuser.Close()
+	if err := NewEnvironment().LoadSystem(ntuser); err != ErrNotExist {
+		t.Errorf("Environment.LoadSystem() without Select key error = %v, want %v", err, ErrNotExist)
+	}
+}
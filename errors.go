@@ -22,6 +22,23 @@ var (
 
 	// ErrCorruptRegistry is returned when there is data corruption or when a read operation fails
 	ErrCorruptRegistry = errors.New("Corrupt registry file")
+
+	// ErrInvalidOffset is returned by OpenKeyAt when the offset does not hold a key
+	ErrInvalidOffset = errors.New("Offset does not hold a key")
+
+	// ErrReadOnly is returned by functions that change the registry
+	// when it was not opened for writing
+	ErrReadOnly = errors.New("Registry opened read only")
+	// ErrInvalidValue is returned when a value can not be stored, like strings with NUL characters
+	ErrInvalidValue = errors.New("Invalid value data")
+	// ErrInvalidName is returned by CreateKey for empty or too long key names
+	ErrInvalidName = errors.New("Invalid key name")
+	// ErrHasSubKeys is returned by DeleteKey when the key has subkeys
+	ErrHasSubKeys = errors.New("Key has subkeys")
+	// ErrExist is returned by CopyTo and Rename when the destination key exists
+	ErrExist = errors.New("Key already exists")
+	// ErrNoDelete is returned by DeleteKey for keys that can not be deleted, like the root key
+	ErrNoDelete = errors.New("Key can not be deleted")
 )
 
 var (
@@ -38,6 +55,19 @@ var (
 	errRootNotFound = errors.New("registry root key not found")
 
 	errInvalidHash = errors.New("Element hash invalid")
+
+	errShortDataBlock = errors.New("Data block segments shorter than value data size")
+
+	errInvalidCellSize  = errors.New("Invalid cell size")
+	errCellNotAllocated = errors.New("Cell is not allocated")
+
+	// errRegHeader is the cause of ParseReg errors for files without a .reg header
+	errRegHeader = errors.New("Missing .reg file header")
+	// errWineHeader is the cause of ParseWine errors for files without a Wine registry header
+	errWineHeader = errors.New("Missing Wine registry header")
+
+	// errBadResource is returned for malformed resources of PE files, which are not part of the registry
+	errBadResource = errors.New("Malformed PE resource")
 )
 
 type errorW struct {
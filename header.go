@@ -12,6 +12,11 @@ type header struct {
 
 	buf []byte
 
+	// primarySequence is incremented before writing changes to the hive
+	// and secondarySequence once they are completely written
+	primarySequence   uint32
+	secondarySequence uint32
+
 	lastModification uint64
 
 	major uint32
@@ -41,6 +46,8 @@ func (h *header) Read() error {
 		return errorW{err: ErrCorruptRegistry, cause: err, function: "header.Read() io.ReadFull"}
 	}
 
+	h.primarySequence = binary.LittleEndian.Uint32(h.buf[4:8])
+	h.secondarySequence = binary.LittleEndian.Uint32(h.buf[8:12])
 	h.lastModification = binary.LittleEndian.Uint64(h.buf[12:20])
 
 	// header versions
@@ -60,6 +67,39 @@ func (h *header) Read() error {
 	return h.validate()
 }
 
+// Write stores the header fields in buf, recalculates the checksum
+// and writes the header to the start of the file
+func (h *header) Write() error {
+	h.update()
+
+	_, err := h.rws.Seek(0, io.SeekStart)
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "header.Write() r.Seek"}
+	}
+	_, err = h.rws.Write(h.buf)
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "header.Write() w.Write"}
+	}
+	return nil
+}
+
+// update stores the header fields in buf and recalculates the checksum
+func (h *header) update() {
+	binary.LittleEndian.PutUint32(h.buf[4:8], h.primarySequence)
+	binary.LittleEndian.PutUint32(h.buf[8:12], h.secondarySequence)
+	binary.LittleEndian.PutUint64(h.buf[12:20], h.lastModification)
+	binary.LittleEndian.PutUint32(h.buf[28:32], h.fileType)
+	binary.LittleEndian.PutUint32(h.buf[36:40], h.rootOffset)
+	binary.LittleEndian.PutUint32(h.buf[40:44], h.binSize)
+	h.xor = h.buf[508:512]
+	copy(h.xor, h.checksum())
+}
+
+// checksum calculates the XOR of the first 508 bytes
+func (h *header) checksum() []byte {
+	return checksum(h.buf)
+}
+
 // validate reads header and validates it
 func (h *header) validate() error {
 	// header magic number
@@ -67,17 +107,12 @@ func (h *header) validate() error {
 		return errBadSignature
 	}
 
-	if binary.LittleEndian.Uint32(h.buf[4:8]) != binary.LittleEndian.Uint32(h.buf[8:12]) {
+	if h.primarySequence != h.secondarySequence {
 		return errBadSequenceNumber
 	}
 
 	// calculate xor from previous bytes
-	calculatedXOR := make([]byte, 4)
-	for i, b := range h.buf[:508] {
-		calculatedXOR[i&3] ^= b
-	}
-
-	if bytes.Compare(calculatedXOR, h.xor) != 0 {
+	if bytes.Compare(h.checksum(), h.xor) != 0 {
 		return errInvalidXOR
 	}
 
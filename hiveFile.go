@@ -0,0 +1,209 @@
+package registry
+
+import (
+	"io"
+	"os"
+	"sort"
+)
+
+// pageSize is the granularity of the changes tracked by hiveFile,
+// the sector size used by the transaction logs
+const pageSize = 512
+
+// hiveFile keeps the changes made to a writable registry in memory
+// until they are committed to the file.
+type hiveFile struct {
+	fp   *os.File
+	name string
+
+	pos  int64
+	size int64
+
+	pages map[int64][]byte // dirty pages by offset
+
+	log bool // write a transaction log before committing
+
+	// undo holds the pages as they were before the changes of the
+	// current operation, nil for pages that were not dirty
+	undo     map[int64][]byte
+	undoSize int64
+}
+
+func newHiveFile(fp *os.File, name string) (*hiveFile, error) {
+	fi, err := fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &hiveFile{
+		fp:    fp,
+		name:  name,
+		size:  fi.Size(),
+		pages: map[int64][]byte{},
+	}, nil
+}
+
+func (f *hiveFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, os.ErrInvalid
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *hiveFile) Read(b []byte) (int, error) {
+	if f.pos >= f.size {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > f.size-f.pos {
+		b = b[:f.size-f.pos]
+	}
+
+	n := 0
+	for n < len(b) {
+		off := f.pos + int64(n)
+		page, start := off-off%pageSize, int(off%pageSize)
+		if p, ok := f.pages[page]; ok {
+			n += copy(b[n:], p[start:])
+			continue
+		}
+
+		// read until the next dirty page from the file
+		end := n + pageSize - start
+		for end < len(b) {
+			if _, ok := f.pages[f.pos+int64(end)]; ok {
+				break
+			}
+			end += pageSize
+		}
+		if end > len(b) {
+			end = len(b)
+		}
+		err := f.readFile(b[n:end], off)
+		if err != nil {
+			return n, err
+		}
+		n = end
+	}
+	f.pos += int64(n)
+	return n, nil
+}
+
+// readFile reads b from the file at off. Bytes past the end of the file are zero.
+func (f *hiveFile) readFile(b []byte, off int64) error {
+	m, err := f.fp.ReadAt(b, off)
+	if err == io.EOF {
+		for i := m; i < len(b); i++ {
+			b[i] = 0
+		}
+		return nil
+	}
+	return err
+}
+
+func (f *hiveFile) Write(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		off := f.pos + int64(n)
+		page, start := off-off%pageSize, int(off%pageSize)
+		p, ok := f.pages[page]
+		if _, saved := f.undo[page]; f.undo != nil && !saved {
+			if ok {
+				f.undo[page] = append([]byte(nil), p...)
+			} else {
+				f.undo[page] = nil
+			}
+		}
+		if !ok {
+			p = make([]byte, pageSize)
+			err := f.readFile(p, page)
+			if err != nil {
+				return n, err
+			}
+			f.pages[page] = p
+		}
+		n += copy(p[start:], b[n:])
+	}
+	f.pos += int64(n)
+	if f.pos > f.size {
+		f.size = f.pos
+	}
+	return n, nil
+}
+
+// begin starts recording the changes so they can be undone by rollback
+func (f *hiveFile) begin() {
+	f.undo = map[int64][]byte{}
+	f.undoSize = f.size
+}
+
+// rollback undoes the changes made since begin
+func (f *hiveFile) rollback() {
+	for page, p := range f.undo {
+		if p == nil {
+			delete(f.pages, page)
+		} else {
+			f.pages[page] = p
+		}
+	}
+	f.size = f.undoSize
+	f.undo = nil
+}
+
+// end stops recording the changes
+func (f *hiveFile) end() {
+	f.undo = nil
+}
+
+// dirty returns the offsets of the dirty pages in order
+func (f *hiveFile) dirty() []int64 {
+	offsets := make([]int64, 0, len(f.pages))
+	for off := range f.pages {
+		offsets = append(offsets, off)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	return offsets
+}
+
+// memFile is an in-memory file used to build hives
+type memFile struct {
+	b   []byte
+	pos int64
+}
+
+func (m *memFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += m.pos
+	case io.SeekEnd:
+		offset += int64(len(m.b))
+	}
+	if offset < 0 {
+		return 0, os.ErrInvalid
+	}
+	m.pos = offset
+	return offset, nil
+}
+
+func (m *memFile) Read(b []byte) (int, error) {
+	if m.pos >= int64(len(m.b)) {
+		return 0, io.EOF
+	}
+	n := copy(b, m.b[m.pos:])
+	m.pos += int64(n)
+	return n, nil
+}
+
+func (m *memFile) Write(b []byte) (int, error) {
+	if end := m.pos + int64(len(b)); end > int64(len(m.b)) {
+		m.b = append(m.b, make([]byte, end-int64(len(m.b)))...)
+	}
+	n := copy(m.b[m.pos:], b)
+	m.pos += int64(n)
+	return n, nil
+}
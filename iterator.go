@@ -0,0 +1,91 @@
+package registry
+
+// SubKeyIterator iterates over the subkeys of a key in the order they
+// are stored in the subkey lists. Subkeys are read lazily, one per Next call.
+//
+//	it := k.SubKeys()
+//	for it.Next() {
+//		sk := it.Key()
+//		...
+//	}
+//	if it.Err() != nil {
+//		...
+//	}
+type SubKeyIterator struct {
+	parent Key
+
+	// stack of lists being iterated. Index roots ("ri") push their sublists
+	lists []*subKeyList
+	pos   []int
+
+	key Key
+	err error
+}
+
+// SubKeys returns an iterator over the subkeys of key k
+func (k Key) SubKeys() *SubKeyIterator {
+	it := &SubKeyIterator{parent: k}
+	if k.nk.numberOfSubKeys == 0 {
+		return it
+	}
+
+	list, err := k.subkeys()
+	if err != nil {
+		it.err = err
+		return it
+	}
+	it.push(list)
+	return it
+}
+
+func (it *SubKeyIterator) push(list *subKeyList) {
+	it.lists = append(it.lists, list)
+	it.pos = append(it.pos, 0)
+}
+
+func (it *SubKeyIterator) pop() {
+	it.lists = it.lists[:len(it.lists)-1]
+	it.pos = it.pos[:len(it.pos)-1]
+}
+
+// Next advances the iterator to the next subkey, which is then available
+// through Key. It returns false when there are no more subkeys or an
+// error occurred.
+func (it *SubKeyIterator) Next() bool {
+	for it.err == nil && len(it.lists) > 0 {
+		top := len(it.lists) - 1
+		list := it.lists[top]
+		if it.pos[top] >= len(list.elements) {
+			it.pop()
+			continue
+		}
+
+		el := list.elements[it.pos[top]]
+		it.pos[top]++
+
+		err := el.ReadElement()
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if el.subKeyList != nil {
+			el.subKeyList.rws = list.rws
+			it.push(el.subKeyList)
+			continue
+		}
+
+		it.key = newKey(it.parent.registry, it.parent.rws, el.namedKey)
+		return true
+	}
+	return false
+}
+
+// Key returns the current subkey
+func (it *SubKeyIterator) Key() Key {
+	return it.key
+}
+
+// Err returns the first error found while iterating
+func (it *SubKeyIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,307 @@
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// JSONOptions are the options of Key.ExportJSON
+type JSONOptions struct {
+	// Lines writes one JSON Lines record per key instead of a single
+	// document with the subkeys nested in their parent key
+	Lines bool
+}
+
+// JSONKey is the JSON record of a key
+type JSONKey struct {
+	Path         string      `json:"path"` // path from the root key of the registry
+	LastModified time.Time   `json:"lastModified"`
+	Class        string      `json:"class,omitempty"`
+	Flags        uint16      `json:"flags"`
+	Values       []JSONValue `json:"values"`
+	SubKeys      []JSONKey   `json:"subKeys,omitempty"` // only in nested documents
+}
+
+// JSONValue is the JSON record of a value. Data is a string for REG_SZ,
+// REG_EXPAND_SZ and REG_LINK values, an array of strings for REG_MULTI_SZ
+// values, a number for REG_DWORD, REG_DWORD_BIG_ENDIAN and REG_QWORD values
+// and base64 for the others. Values whose data does not fit their type,
+// like strings without a terminating NUL character, have their data
+// in Raw, as base64.
+type JSONValue struct {
+	Name     string      `json:"name"` // empty for the default value
+	Type     string      `json:"type"` // as returned by Type
+	TypeCode uint32      `json:"typeCode"`
+	Data     interface{} `json:"data,omitempty"`
+	Raw      []byte      `json:"raw,omitempty"`
+}
+
+// ExportJSON writes key k, its values and subkeys to w as JSON,
+// streaming the keys as they are read
+func (k Key) ExportJSON(w io.Writer, opts *JSONOptions) error {
+	if opts == nil {
+		opts = &JSONOptions{}
+	}
+	path, err := k.Path()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	err = exportJSONKey(bw, k, path, opts.Lines)
+	if err != nil {
+		return err
+	}
+	if !opts.Lines {
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// exportJSONKey writes key k, known as path, and its subkeys
+func exportJSONKey(w *bufio.Writer, k Key, path string, lines bool) error {
+	k = newKey(k.registry, k.rws, k.nk) // keep the cursor of k
+	rec, err := newJSONKey(k, path)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	names, err := k.ReadSubKeyNames(-1)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(names, func(i, j int) bool { return compareKeyNames(names[i], names[j]) < 0 })
+
+	if lines {
+		w.Write(b)
+		w.WriteByte('\n')
+	} else {
+		// the subkeys are written inside the record, before its closing brace
+		w.Write(b[:len(b)-1])
+		if len(names) > 0 {
+			w.WriteString(`,"subKeys":[`)
+		}
+	}
+	for i, name := range names {
+		sub, err := k.OpenSubKey(name)
+		if err != nil {
+			return err
+		}
+		if i > 0 && !lines {
+			w.WriteByte(',')
+		}
+		p := name
+		if path != "" {
+			p = path + string(separator) + name
+		}
+		err = exportJSONKey(w, sub, p, lines)
+		if err != nil {
+			return err
+		}
+	}
+	if !lines {
+		if len(names) > 0 {
+			w.WriteByte(']')
+		}
+		w.WriteByte('}')
+	}
+	return nil
+}
+
+// newJSONKey returns the record of key k without its subkeys
+func newJSONKey(k Key, path string) (JSONKey, error) {
+	rec := JSONKey{
+		Path:         path,
+		LastModified: date(k.nk.lastModified),
+		Flags:        k.nk.flags,
+		Values:       []JSONValue{},
+	}
+	if k.nk.classNameSize > 0 && k.nk.classNameOffset != noOffset {
+		class, err := readCellData(k.rws, k.nk.binOffset, k.nk.classNameOffset, uint32(k.nk.classNameSize))
+		if err != nil {
+			return rec, err
+		}
+		rec.Class = stringFromBytes(append(class, 0, 0))
+	}
+
+	values, err := k.ReadValues(-1)
+	if err != nil {
+		return rec, err
+	}
+	for _, v := range values {
+		rec.Values = append(rec.Values, newJSONValue(v))
+	}
+	return rec, nil
+}
+
+// newJSONValue returns the record of value v
+func newJSONValue(v Value) JSONValue {
+	jv := JSONValue{Type: Type(v.Type), TypeCode: v.Type}
+	if v.Name != defaultValueName {
+		jv.Name = v.Name
+	}
+
+	raw := v.raw
+	switch v.Type {
+	case REG_SZ, REG_EXPAND_SZ, REG_LINK:
+		if s, ok := regString(raw); ok && len(raw) > 0 {
+			jv.Data = s
+			return jv
+		}
+	case REG_MULTI_SZ:
+		strs := stringsFromBytes(raw)
+		if b, err := multiStringBytes(strs); err == nil && bytes.Equal(b, raw) {
+			jv.Data = append([]string{}, strs...)
+			return jv
+		}
+	case REG_DWORD:
+		if len(raw) == 4 {
+			jv.Data = binary.LittleEndian.Uint32(raw)
+			return jv
+		}
+	case REG_DWORD_BIG_ENDIAN:
+		if len(raw) == 4 {
+			jv.Data = binary.BigEndian.Uint32(raw)
+			return jv
+		}
+	case REG_QWORD:
+		if len(raw) == 8 {
+			jv.Data = binary.LittleEndian.Uint64(raw)
+			return jv
+		}
+	default:
+		jv.Data = base64.StdEncoding.EncodeToString(raw)
+		return jv
+	}
+	jv.Raw = append([]byte{}, raw...)
+	return jv
+}
+
+// ImportJSON creates the keys and values of the JSON written by
+// Key.ExportJSON, in either format, keeping their last write times,
+// class names and flags. If the import fails, the registry is left unchanged.
+func (r Registry) ImportJSON(rd io.Reader) error {
+	d := json.NewDecoder(rd)
+	d.UseNumber()
+
+	var keys []importRecord
+	for {
+		var rec JSONKey
+		err := d.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errorW{err: ErrBadRegistry, cause: err, function: "ImportJSON"}
+		}
+		keys, err = appendJSONKey(keys, rec)
+		if err != nil {
+			return errorW{err: ErrBadRegistry, cause: err, function: "ImportJSON"}
+		}
+	}
+	return r.importKeys(keys, "")
+}
+
+// appendJSONKey appends record rec and its subkeys to keys
+func appendJSONKey(keys []importRecord, rec JSONKey) ([]importRecord, error) {
+	flags := rec.Flags
+	key := importRecord{
+		RegKey:       RegKey{Path: rec.Path},
+		class:        rec.Class,
+		lastModified: rec.LastModified,
+		flags:        &flags,
+	}
+	for _, jv := range rec.Values {
+		v, err := jv.regValue()
+		if err != nil {
+			return nil, err
+		}
+		key.Values = append(key.Values, v)
+	}
+	keys = append(keys, key)
+
+	for _, sub := range rec.SubKeys {
+		var err error
+		keys, err = appendJSONKey(keys, sub)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// regValue returns the value of record jv
+func (jv JSONValue) regValue() (RegValue, error) {
+	v := RegValue{Name: jv.Name, Type: jv.TypeCode}
+	if jv.Raw != nil || jv.Data == nil {
+		v.Data = append([]byte{}, jv.Raw...)
+		return v, nil
+	}
+
+	var err error
+	switch v.Type {
+	case REG_SZ, REG_EXPAND_SZ, REG_LINK:
+		s, ok := jv.Data.(string)
+		if !ok {
+			break
+		}
+		v.Data = append(utf16LEFromString(s), 0, 0)
+		return v, nil
+	case REG_MULTI_SZ:
+		list, ok := jv.Data.([]interface{})
+		if !ok {
+			break
+		}
+		strs := make([]string, len(list))
+		for i, s := range list {
+			if strs[i], ok = s.(string); !ok {
+				return v, fmt.Errorf("value %q: invalid string %v", jv.Name, s)
+			}
+		}
+		v.Data, err = multiStringBytes(strs)
+		return v, err
+	case REG_DWORD, REG_DWORD_BIG_ENDIAN, REG_QWORD:
+		n, ok := jv.Data.(json.Number)
+		if !ok {
+			break
+		}
+		bits := 32
+		if v.Type == REG_QWORD {
+			bits = 64
+		}
+		u, err := strconv.ParseUint(n.String(), 10, bits)
+		if err != nil {
+			return v, fmt.Errorf("value %q: %v", jv.Name, err)
+		}
+		v.Data = make([]byte, bits/8)
+		switch v.Type {
+		case REG_DWORD:
+			binary.LittleEndian.PutUint32(v.Data, uint32(u))
+		case REG_DWORD_BIG_ENDIAN:
+			binary.BigEndian.PutUint32(v.Data, uint32(u))
+		default:
+			binary.LittleEndian.PutUint64(v.Data, u)
+		}
+		return v, nil
+	default:
+		s, ok := jv.Data.(string)
+		if !ok {
+			break
+		}
+		v.Data, err = base64.StdEncoding.DecodeString(s)
+		return v, err
+	}
+	return v, fmt.Errorf("value %q: invalid data for type %v", jv.Name, v.Type)
+}
@@ -0,0 +1,124 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKey_ExportJSON(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	src, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	root, err := src.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, _, err := root.CreateKey(`JSON\Sub`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.setClassName("Class"); err != nil {
+		t.Fatal(err)
+	}
+	k, _ = k.Parent()
+	for _, set := range []error{
+		k.SetStringValue("", "default"),
+		k.SetStringsValue("Multi", []string{"a", "b"}),
+		k.SetQWordValue("QWord", 1<<63+1),
+		k.setValue("BigEndian", REG_DWORD_BIG_ENDIAN, []byte{0, 0, 1, 2}),
+		k.SetBinaryValue("Binary", []byte{1, 2, 3}),
+		k.setValue("Unterminated", REG_SZ, []byte{'a', 0}),
+		k.setValue("Custom", 0x20, []byte{1}),
+	} {
+		if set != nil {
+			t.Fatal(set)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := k.ExportJSON(&buf, &JSONOptions{Lines: true}); err != nil {
+		t.Fatalf("Key.ExportJSON() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Key.ExportJSON() wrote %v lines, want 2", len(lines))
+	}
+	var rec struct {
+		Path   string
+		Values []json.RawMessage
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`{"name":"","type":"REG_SZ","typeCode":1,"data":"default"}`,
+		`{"name":"Multi","type":"REG_MULTI_SZ","typeCode":7,"data":["a","b"]}`,
+		`{"name":"QWord","type":"REG_QWORD_LITTLE_ENDIAN","typeCode":11,"data":9223372036854775809}`,
+		`{"name":"BigEndian","type":"REG_DWORD_BIG_ENDIAN","typeCode":5,"data":258}`,
+		`{"name":"Binary","type":"REG_BINARY","typeCode":3,"data":"AQID"}`,
+		`{"name":"Unterminated","type":"REG_SZ","typeCode":1,"raw":"YQA="}`,
+		`{"name":"Custom","type":"","typeCode":32,"data":"AQ=="}`,
+	}
+	if rec.Path != "JSON" || len(rec.Values) != len(want) {
+		t.Fatalf("Key.ExportJSON() = %v", lines[0])
+	}
+	for i, v := range rec.Values {
+		if string(v) != want[i] {
+			t.Errorf("value %v = %s, want %s", i, v, want[i])
+		}
+	}
+	if !strings.Contains(lines[1], `"path":"JSON\\Sub"`) || !strings.Contains(lines[1], `"class":"Class"`) {
+		t.Errorf("Key.ExportJSON() = %v", lines[1])
+	}
+
+	// both formats can be imported back
+	for _, lines := range []bool{false, true} {
+		buf.Reset()
+		if err := root.ExportJSON(&buf, &JSONOptions{Lines: lines}); err != nil {
+			t.Fatalf("Key.ExportJSON() error = %v", err)
+		}
+		if !lines && !json.Valid(buf.Bytes()) {
+			t.Fatalf("Key.ExportJSON() is not valid JSON")
+		}
+
+		name := filepath.Join(filepath.Dir(file), "json.dat")
+		d, err := Create(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.ImportJSON(&buf); err != nil {
+			t.Fatalf("Registry.ImportJSON() error = %v", err)
+		}
+		if got, want := testTree(t, d), testTree(t, src); !reflect.DeepEqual(got, want) {
+			t.Errorf("imported registry has %v keys, want %v", len(got), len(want))
+		}
+		err = root.walk("", func(path string, s Key) error {
+			k, err := d.OpenKey(path)
+			if err != nil {
+				return err
+			}
+			if k.nk.lastModified != s.nk.lastModified || k.nk.flags != s.nk.flags || k.nk.classNameSize != s.nk.classNameSize {
+				t.Errorf("key %q = %x %x %v, want %x %x %v", path, k.nk.lastModified, k.nk.flags, k.nk.classNameSize,
+					s.nk.lastModified, s.nk.flags, s.nk.classNameSize)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(name)
+	}
+}
@@ -15,6 +15,20 @@ type Key struct {
 	rws io.ReadWriteSeeker
 
 	registry Registry
+
+	cursor *keyCursor // shared by copies of the key, like an os.File offset
+}
+
+// keyCursor keeps the position of the Read* functions
+type keyCursor struct {
+	values int // next value read by ReadValues
+
+	// remaining names, set on the first ReadValueNames or ReadSubKeyNames call
+	valueNames  []string
+	subKeyNames []string
+
+	// remaining values of a MergedKey, set on the first ReadValues call
+	mergedValues []Value
 }
 
 func newKey(r Registry, rws io.ReadWriteSeeker, nk *namedKey) Key {
@@ -22,54 +36,106 @@ func newKey(r Registry, rws io.ReadWriteSeeker, nk *namedKey) Key {
 		registry: r,
 		rws:      rws,
 		nk:       nk,
+		cursor:   &keyCursor{},
 	}
 }
 
-// OpenSubKey opens the subkey located at path
-func (k Key) OpenSubKey(path string) (Key, error) {
+// OpenSubKey opens the subkey located at path.
+// access may hold WOW64_32KEY to open the key seen by 32-bit applications
+// of 64-bit systems, redirected to WOW6432Node like Windows 7 and later do.
+// Other access rights are ignored.
+func (k Key) OpenSubKey(path string, access ...uint32) (Key, error) {
+	path = k.controlSetPath(strings.Trim(path, string(separator)))
+	if wow64Access(access) {
+		// the redirected key may have subkeys when k has none
+		return k.openWow64(path)
+	}
 	if k.nk.numberOfSubKeys == 0 {
 		return Key{}, ErrNotExist
 	}
-
-	path = strings.Trim(path, string(separator))
 	return k.openSubKey(strings.Split(path, string(separator)))
 }
 
 func (k Key) openSubKey(entries []string) (Key, error) {
-	// recursive
-	// calculate hash "entries[0]"
-	// check sublist hash
-	// recurse with new key
-	// if not found return error
 	if len(entries) == 0 {
 		return k, nil
 	}
+	if k.nk.numberOfSubKeys == 0 {
+		return Key{}, ErrNotExist
+	}
 
 	list, err := k.subkeys()
 	if err != nil {
 		return Key{}, err
 	}
-	hash := lhSubKeyHash(entries[0])
-	els, err := list.allElements()
+	nk, err := list.find(entries[0])
 	if err != nil {
 		return Key{}, err
 	}
+	return newKey(k.registry, k.rws, nk).openSubKey(entries[1:])
+}
 
-	for _, sk := range els {
-		if sk.hashValue == hash {
-			nKey := newKey(
-				k.registry,
-				k.rws,
-				newNamedKey(k.rws, k.nk.binOffset, list.binOffset+int64(sk.namedKeyOffset)),
-			)
-			err := nKey.nk.Read()
-			if err != nil {
-				return Key{}, err
-			}
-			return nKey.openSubKey(entries[1:])
+// Name returns the name of key k. The root key has the name
+// given to it when the hive was created, usually "ROOT".
+func (k Key) Name() string {
+	return k.nk.name
+}
+
+// Parent opens the parent of key k.
+// If k is the root key, Parent returns ErrNotExist.
+func (k Key) Parent() (Key, error) {
+	if k.nk.isRoot() {
+		return Key{}, ErrNotExist
+	}
+
+	nk := newNamedKey(k.rws, k.nk.binOffset, k.nk.binOffset+int64(k.nk.parentKeyOffset))
+	err := nk.Read()
+	if err != nil {
+		return Key{}, err
+	}
+	return newKey(k.registry, k.rws, nk), nil
+}
+
+// Path returns the path of key k relative to the root key, as
+// accepted by Registry.OpenKey. The path of the root key is empty.
+// Parents looping back to a key of the path, in a corrupt hive,
+// return ErrCorruptRegistry.
+func (k Key) Path() (string, error) {
+	var names []string
+	seen := map[int64]bool{}
+	for !k.nk.isRoot() {
+		if seen[k.Offset()] {
+			return "", ErrCorruptRegistry
 		}
+		seen[k.Offset()] = true
+		names = append(names, k.nk.name)
+
+		var err error
+		k, err = k.Parent()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
 	}
-	return Key{}, ErrNotExist
+	return strings.Join(names, string(separator)), nil
+}
+
+// Offset returns the offset of the cell of key k, relative to the
+// start of the hive bins data. Together with the registry it identifies
+// the key and can be passed to Registry.OpenKeyAt.
+func (k Key) Offset() int64 {
+	return k.nk.fpOffset - k.nk.binOffset
+}
+
+// Equal reports whether k and o are the same key of the same opened registry
+func (k Key) Equal(o Key) bool {
+	if k.nk == nil || o.nk == nil {
+		return k.nk == o.nk
+	}
+	return k.registry.header == o.registry.header && k.Offset() == o.Offset()
 }
 
 // Close closes open key k.
@@ -148,13 +214,20 @@ func (k Key) GetIntegerValue(name string) (val uint64, valtype uint32, err error
 
 // GetMUIStringValue retrieves the localized string value for
 // the specified value name associated with an open key k.
-// If the value name doesn't exist or the localized string value
-// can't be resolved, GetMUIStringValue returns ErrNotExist.
-// GetMUIStringValue panics if the system doesn't support
-// regLoadMUIString; use LoadRegLoadMUIString to check if
-// regLoadMUIString is supported before calling this function.
+// If the value name doesn't exist, GetMUIStringValue returns ErrNotExist.
+// Values that are not an indirect string ("@file,-id") are returned as is.
+// Indirect strings need the files of the system that owns the registry,
+// which a Key does not know: GetMUIStringValue returns ErrNotExist for
+// all of them. Use GetMUIStringValueWith to resolve them.
 func (k Key) GetMUIStringValue(name string) (string, error) {
-	return "", errors.New("Unsupported function")
+	val, _, err := k.GetStringValue(name)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(val, "@") {
+		return "", ErrNotExist
+	}
+	return val, nil
 }
 
 // GetStringValue retrieves the string value for the specified
@@ -255,43 +328,41 @@ func (k Key) GetValue(name string, buf []byte) (n int, valtype uint32, err error
 }
 
 func (k Key) getValue(name string) (*valueKey, error) {
-	list := k.nk.values
-	for i := 0; i < list.Len(); i++ {
-		value, err := list.Value(uint(i))
-		if err != nil || value.name == name {
-			return value, err
-		}
+	i, err := k.valueIndex(name)
+	if err != nil {
+		return nil, err
 	}
-	return nil, ErrNotExist
+	return k.nk.values.Value(uint(i))
 }
 
-// ReadSubKeyNames returns the names of subkeys of key k.
+// ReadSubKeyNames returns the names of subkeys of key k, sorted,
+// continuing where the previous call stopped.
 // The parameter n controls the number of returned names,
 // analogous to the way os.File.Readdirnames works.
+//
+// If n > 0, ReadSubKeyNames returns at most n names. In this case, if
+// ReadSubKeyNames returns an empty slice, it will return io.EOF.
+//
+// If n <= 0, ReadSubKeyNames returns all the remaining names and a nil error.
 func (k Key) ReadSubKeyNames(n int) ([]string, error) {
-	if n == 0 || k.nk.numberOfSubKeys == 0 { // TODO: check behavior on microsoft api
-		return []string{}, nil
-	}
-
-	list, err := k.subkeys()
-	if err != nil {
-		return nil, err
+	if k.cursor == nil {
+		return nil, ErrNotExist // the zero Key, not opened
 	}
 
-	max := int(k.nk.numberOfSubKeys)
-	if n < 0 {
-		n = max
-	}
-	if n > max {
-		n = max
+	if k.cursor.subKeyNames == nil {
+		names := make([]string, 0, k.nk.numberOfSubKeys)
+		it := k.SubKeys()
+		for it.Next() {
+			names = append(names, it.Key().nk.name)
+		}
+		if it.Err() != nil {
+			return nil, it.Err()
+		}
+		sort.Strings(names)
+		k.cursor.subKeyNames = names
 	}
 
-	names, err := list.subkeyNames(n)
-	if err != nil {
-		return nil, err
-	}
-	sort.Strings(names)
-	return names, nil
+	return nextNames(&k.cursor.subKeyNames, n)
 }
 
 func (k Key) subkeys() (*subKeyList, error) {
@@ -307,32 +378,81 @@ func (k Key) subkeys() (*subKeyList, error) {
 	return list, list.Read()
 }
 
-// ReadValueNames returns the value names of key k.
+// ReadValueNames returns the value names of key k, sorted,
+// continuing where the previous call stopped.
 // The parameter n controls the number of returned names,
 // analogous to the way os.File.Readdirnames works.
+//
+// If n > 0, ReadValueNames returns at most n names. In this case, if
+// ReadValueNames returns an empty slice, it will return io.EOF.
+//
+// If n <= 0, ReadValueNames returns all the remaining names and a nil error.
 func (k Key) ReadValueNames(n int) ([]string, error) {
-	if n == 0 || k.nk.numberOfValues == 0 { // TODO: check behavior on microsoft api
-		return []string{}, nil
+	if k.cursor == nil {
+		return nil, ErrNotExist // the zero Key, not opened
 	}
 
-	list := k.nk.values
-	max := len(list.values)
-	if n < 0 {
-		n = max
+	if k.cursor.valueNames == nil {
+		list := k.nk.values
+		names := make([]string, list.Len())
+		for i := range names {
+			value, err := list.Value(uint(i))
+			if err != nil {
+				return nil, err
+			}
+			names[i] = value.name
+		}
+		sort.Strings(names)
+		k.cursor.valueNames = names
+	}
+
+	return nextNames(&k.cursor.valueNames, n)
+}
+
+// nextNames removes and returns up to n names from the start of names,
+// following os.File.Readdirnames semantics
+func nextNames(names *[]string, n int) ([]string, error) {
+	left := *names
+	if n > 0 && len(left) == 0 {
+		return []string{}, io.EOF
 	}
-	if n > max {
-		n = max
+	if n <= 0 || n > len(left) {
+		n = len(left)
+	}
+	*names = left[n:]
+	return left[:n:n], nil
+}
+
+// ReadValues reads the values of key k in the order they are stored
+// and returns a slice of up to n values, continuing where the previous
+// call stopped, analogous to the way os.File.ReadDir works.
+//
+// If n > 0, ReadValues returns at most n values. In this case, if
+// ReadValues returns an empty slice, it will return io.EOF.
+//
+// If n <= 0, ReadValues returns all the remaining values and a nil error.
+func (k Key) ReadValues(n int) ([]Value, error) {
+	if k.cursor == nil {
+		return nil, ErrNotExist // the zero Key, not opened
 	}
 
-	names := make([]string, n)
+	list := k.nk.values
+	left := list.Len() - k.cursor.values
+	if n > 0 && left == 0 {
+		return []Value{}, io.EOF
+	}
+	if n <= 0 || n > left {
+		n = left
+	}
 
+	values := make([]Value, 0, n)
 	for i := 0; i < n; i++ {
-		value, err := list.Value(uint(i))
+		vk, err := list.Value(uint(k.cursor.values))
 		if err != nil {
-			return nil, err
+			return values, err
 		}
-		names[i] = value.name
+		values = append(values, newValue(vk))
+		k.cursor.values++
 	}
-	sort.Strings(names)
-	return names, nil
+	return values, nil
 }
@@ -0,0 +1,208 @@
+package registry
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// CopyTo copies key k, its values and subkeys as subkey name of dst and
+// returns the copy. dst may belong to another registry, opened for writing.
+// Value data, class names and security descriptors are copied, the
+// security descriptors found in the registry of dst are reused.
+// If the copy fails, the registry of dst is left unchanged.
+func (k Key) CopyTo(dst Key, name string) (Key, error) {
+	r := dst.registry
+	if r.cells == nil {
+		return Key{}, ErrReadOnly
+	}
+	if !validKeyName(name) {
+		return Key{}, ErrInvalidName
+	}
+	_, err := dst.openSubKey([]string{name})
+	if err == nil {
+		return Key{}, ErrExist
+	}
+	if err != ErrNotExist {
+		return Key{}, err
+	}
+
+	// the copy is added to dst once complete, so copying a key
+	// to one of its subkeys does not copy the copy
+	var off uint32
+	err = r.atomic(func() error {
+		b, err := newKeyCopier(r)
+		if err != nil {
+			return err
+		}
+		off, err = b.copyKey(k, name, uint32(dst.Offset()))
+		if err != nil {
+			return err
+		}
+		return dst.addSubKey(off, name)
+	})
+	if err != nil {
+		dst.nk.Read()
+		return Key{}, err
+	}
+	return r.OpenKeyAt(int64(off))
+}
+
+// Rename changes the name of key k. The subkey list of the parent
+// key is kept sorted. If the rename fails, the registry is left unchanged.
+func (k Key) Rename(name string) error {
+	r := k.registry
+	if r.cells == nil {
+		return ErrReadOnly
+	}
+	if !validKeyName(name) {
+		return ErrInvalidName
+	}
+
+	var parent Key
+	if !k.nk.isRoot() {
+		var err error
+		parent, err = k.Parent()
+		if err != nil {
+			return err
+		}
+		other, err := parent.openSubKey([]string{name})
+		if err == nil && !other.Equal(k) {
+			return ErrExist
+		}
+		if err != nil && err != ErrNotExist {
+			return err
+		}
+	}
+
+	err := r.atomic(func() error {
+		return k.rename(parent, name)
+	})
+	if err != nil {
+		k.nk.Read()
+		return err
+	}
+	return nil
+}
+
+// rename writes the new name of k, moving the key to a bigger cell if needed.
+// parent is the parent key of k, unless k is the root key.
+func (k Key) rename(parent Key, name string) error {
+	a := k.registry.cells
+	off := uint32(k.Offset())
+
+	var err error
+	root := k.nk.isRoot()
+	if !root {
+		parent.nk.subKeysListOffset, err = removeSubKey(a, parent.nk.subKeysListOffset, off)
+		if err != nil {
+			return err
+		}
+	}
+
+	n, compressed := encodeName(name)
+	moved, err := a.realloc(off, 76+len(n))
+	if err != nil {
+		return err
+	}
+	err = a.writeCell(moved+76, n)
+	if err != nil {
+		return err
+	}
+
+	nk := newNamedKey(k.rws, k.nk.binOffset, k.nk.binOffset+int64(moved))
+	err = nk.Read()
+	if err != nil {
+		return err
+	}
+	nk.flags &^= nk_KEY_COMP_NAME
+	if compressed {
+		nk.flags |= nk_KEY_COMP_NAME
+	}
+	nk.keyNameSize = uint16(len(n))
+	nk.lastModified = filetime(time.Now())
+	err = nk.Write()
+	if err != nil {
+		return err
+	}
+
+	if moved != off {
+		err = k.moved(moved)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !root {
+		list := parent.nk.subKeysListOffset
+		parent.nk.subKeysListOffset, err = insertSubKey(a, list, moved, name)
+		if err != nil {
+			return err
+		}
+		if n := utf16Size(name); n > parent.nk.largestSubKeyNameSize {
+			parent.nk.largestSubKeyNameSize = n
+		}
+		err = parent.writeNamedKey()
+		if err != nil {
+			return err
+		}
+	}
+
+	k.nk.fpOffset = k.nk.binOffset + int64(moved)
+	return k.nk.Read()
+}
+
+// moved updates the references to key k, moved to offset off:
+// the parent offset of its subkeys and, for the root key, the header
+func (k Key) moved(off uint32) error {
+	a := k.registry.cells
+
+	if k.nk.numberOfSubKeys > 0 {
+		children, err := subKeyOffsets(a, k.nk.subKeysListOffset)
+		if err != nil {
+			return err
+		}
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, off)
+		for _, child := range children {
+			err = a.writeCell(child+16, b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if k.nk.isRoot() {
+		h := k.registry.header
+		h.rootOffset = off
+		err := h.Write()
+		if err != nil {
+			return err
+		}
+		r := k.registry.root
+		r.fpOffset = r.binOffset + int64(off)
+		return r.Read()
+	}
+	return nil
+}
+
+// subKeyOffsets returns the offsets of the named keys of the subkey list at offset list
+func subKeyOffsets(a *cellAllocator, list uint32) ([]uint32, error) {
+	sig, entries, err := readSubKeyList(a, list)
+	if err != nil {
+		return nil, err
+	}
+
+	var offsets []uint32
+	for _, e := range entries {
+		if sig != subKeyList4Sig {
+			offsets = append(offsets, e.offset)
+			continue
+		}
+		leaf, err := subKeyOffsets(a, e.offset)
+		if err != nil {
+			return nil, err
+		}
+		offsets = append(offsets, leaf...)
+	}
+	return offsets, nil
+}
@@ -0,0 +1,230 @@
+package registry
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKey_CopyTo(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+	path := `SOFTWARE\Microsoft\InputPersonalization`
+
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	src, err := r.OpenKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SetBinaryValue("Big", bytes.Repeat([]byte{9}, 30000)); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := Create(filepath.Join(filepath.Dir(file), "dst.dat"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := d.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := src.CopyTo(dst, "Copied")
+	if err != nil {
+		t.Fatalf("Key.CopyTo() error = %v", err)
+	}
+	if _, err := src.CopyTo(dst, "copied"); err != ErrExist {
+		t.Errorf("Key.CopyTo() of existing key error = %v, want %v", err, ErrExist)
+	}
+	if c.Name() != "Copied" {
+		t.Errorf("copy name = %v", c.Name())
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = OpenFile(filepath.Join(filepath.Dir(file), "dst.dat"), os.O_RDWR)
+	if err != nil {
+		t.Fatalf("OpenFile() of destination error = %v", err)
+	}
+	defer d.Close()
+
+	want := map[string][]Value{}
+	err = src.walk("", func(path string, k Key) error {
+		values, err := k.ReadValues(-1)
+		want[path] = values
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := testTree(t, d)
+	for path, values := range want {
+		p := "Copied"
+		if path != "" {
+			p += `\` + path
+		}
+		if !reflect.DeepEqual(got[p], values) {
+			t.Errorf("values of %v = %v, want %v", p, got[p], values)
+		}
+	}
+	if len(got) != len(want)+1 {
+		t.Errorf("destination has %v keys, want %v", len(got), len(want)+1)
+	}
+
+	c, err = d.OpenKey("Copied")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ssk := newSecurityKey(src.rws, hiveBinsOffset+cellHeaderSize, src.nk.securityKeyOffset)
+	csk := newSecurityKey(c.rws, hiveBinsOffset+cellHeaderSize, c.nk.securityKeyOffset)
+	if ssk.Read() != nil || csk.Read() != nil || !bytes.Equal(ssk.ntSecurityDescriptor, csk.ntSecurityDescriptor) {
+		t.Errorf("security descriptor of copy = %x, want %x", csk.ntSecurityDescriptor, ssk.ntSecurityDescriptor)
+	}
+
+	// copying a key inside itself copies it once
+	k, err := r.OpenKey("Environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.CopyTo(k, "Self"); err != nil {
+		t.Fatalf("Key.CopyTo() to itself error = %v", err)
+	}
+	if _, err := r.OpenKey(`Environment\Self\Self`); err != ErrNotExist {
+		t.Errorf("copy of copy error = %v, want %v", err, ErrNotExist)
+	}
+}
+
+func TestKey_CopyTo_rollback(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// a value key that can not be copied, after some keys were copied
+	bad, err := r.OpenKey(`SOFTWARE\Microsoft\Windows\CurrentVersion\Explorer\Advanced`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.cells.writeCell(bad.nk.values.offsets[0], []byte("xx")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := r.OpenKey("Environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := r.OpenKey(`SOFTWARE\Microsoft\Windows\CurrentVersion`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binSize, freeCells := r.header.binSize, append([]freeCell(nil), r.cells.freeCells...)
+
+	if _, err := src.CopyTo(dst, "Copy"); err == nil {
+		t.Fatal("Key.CopyTo() error = nil")
+	}
+	if len(r.file.pages) != 0 || r.header.binSize != binSize || !reflect.DeepEqual(r.cells.freeCells, freeCells) {
+		t.Errorf("registry changed: %v dirty pages, bin size %v, want %v", len(r.file.pages), r.header.binSize, binSize)
+	}
+	if _, err := dst.OpenSubKey("Copy"); err != ErrNotExist {
+		t.Errorf("failed copy error = %v, want %v", err, ErrNotExist)
+	}
+}
+
+func TestKey_Rename(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := r.OpenKey(`SOFTWARE\Microsoft`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := "Microsoft " + strings.Repeat("名", 40)
+
+	if err := k.Rename("Classes"); err != nil {
+		t.Errorf("Key.Rename() to an existing name of another tree error = %v", err)
+	}
+	parent, err := k.Parent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := parent.CreateKey("Other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Rename("classes"); err != ErrExist {
+		t.Errorf("Key.Rename() to an existing name error = %v, want %v", err, ErrExist)
+	}
+	if err := k.Rename(long); err != nil {
+		t.Fatalf("Key.Rename() error = %v", err)
+	}
+	if err := k.Rename(strings.ToUpper(long)); err != nil {
+		t.Fatalf("Key.Rename() changing the case error = %v", err)
+	}
+	root, err := r.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := root.Rename("NewRoot"); err != nil {
+		t.Fatalf("Key.Rename() of root error = %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	root, _ = r.OpenKey("")
+	if root.Name() != "NewRoot" {
+		t.Errorf("root name = %v", root.Name())
+	}
+	if _, err := r.OpenKey(`SOFTWARE\Microsoft`); err != ErrNotExist {
+		t.Errorf("old name error = %v, want %v", err, ErrNotExist)
+	}
+	k, err = r.OpenKey(`SOFTWARE\` + long)
+	if err != nil {
+		t.Fatalf("OpenKey() of renamed key error = %v", err)
+	}
+	if k.Name() != strings.ToUpper(long) {
+		t.Errorf("Key.Name() = %v", k.Name())
+	}
+	sub, err := k.OpenSubKey(`Windows\CurrentVersion`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := sub.Path()
+	if err != nil || p != `SOFTWARE\`+strings.ToUpper(long)+`\Windows\CurrentVersion` {
+		t.Errorf("Key.Path() = %v %v", p, err)
+	}
+
+	parent, _ = r.OpenKey("SOFTWARE")
+	names, err := parent.ReadSubKeyNames(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if _, err := parent.OpenSubKey(name); err != nil {
+			t.Errorf("OpenSubKey(%v) error = %v", name, err)
+		}
+	}
+}
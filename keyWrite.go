@@ -0,0 +1,547 @@
+package registry
+
+import (
+	"encoding/binary"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+)
+
+const (
+	// maxLeafElements is the number of elements of a subkey list before it
+	// is split and referenced from an index root ("ri")
+	maxLeafElements = 1012
+
+	// maxKeyNameLength is the maximum length of a key name in characters
+	maxKeyNameLength = 255
+)
+
+// CreateKey creates a key named path under open key k.
+// Missing intermediate keys are created as well.
+// CreateKey returns the new key and a boolean flag that reports
+// whether the key already existed.
+func (k Key) CreateKey(path string) (newk Key, openedExisting bool, err error) {
+	if k.registry.cells == nil {
+		return Key{}, false, ErrReadOnly
+	}
+
+	path = strings.Trim(path, string(separator))
+	if path == "" {
+		return Key{}, false, ErrInvalidName
+	}
+	path = k.controlSetPath(path)
+
+	openedExisting = true
+	for _, name := range strings.Split(path, string(separator)) {
+		sub, err := k.openSubKey([]string{name})
+		if err == ErrNotExist {
+			openedExisting = false
+			sub, err = k.createSubKey(name)
+		}
+		if err != nil {
+			return Key{}, false, err
+		}
+		k = sub
+	}
+	return k, openedExisting, nil
+}
+
+// DeleteKey deletes the subkey path of key k and its values.
+// Like the Windows RegDeleteKey function, the key must not have subkeys,
+// otherwise ErrHasSubKeys is returned.
+func (k Key) DeleteKey(path string) error {
+	a := k.registry.cells
+	if a == nil {
+		return ErrReadOnly
+	}
+
+	sub, err := k.OpenSubKey(path)
+	if err != nil {
+		return err
+	}
+	if sub.nk.numberOfSubKeys > 0 || sub.nk.numberOfVolatileSubKeys > 0 {
+		return ErrHasSubKeys
+	}
+	if sub.nk.flags&(nk_KEY_NO_DELETE|nk_KEY_HIVE_ENTRY) != 0 {
+		return ErrNoDelete
+	}
+
+	parent, err := sub.Parent()
+	if err != nil {
+		return err
+	}
+	parent.nk.subKeysListOffset, err = removeSubKey(a, parent.nk.subKeysListOffset, uint32(sub.Offset()))
+	if err != nil {
+		return err
+	}
+	parent.nk.numberOfSubKeys--
+
+	err = freeNamedKey(a, sub.nk)
+	if err != nil {
+		return err
+	}
+	err = parent.writeNamedKey()
+	if err != nil {
+		return err
+	}
+
+	// refresh k if it is the parent
+	if k.Equal(parent) {
+		return k.nk.Read()
+	}
+	return nil
+}
+
+// deleteTree deletes the subkey path of key k with its subkeys
+func (k Key) deleteTree(path string) error {
+	sub, err := k.OpenSubKey(path)
+	if err != nil {
+		return err
+	}
+	names, err := sub.ReadSubKeyNames(-1)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		err = sub.deleteTree(name)
+		if err != nil {
+			return err
+		}
+	}
+	return k.DeleteKey(path)
+}
+
+// createSubKey creates subkey name of k, sharing the security descriptor of k
+func (k Key) createSubKey(name string) (Key, error) {
+	a := k.registry.cells
+
+	if !validKeyName(name) {
+		return Key{}, ErrInvalidName
+	}
+
+	err := retainSecurityKey(a, k.nk.securityKeyOffset)
+	if err != nil {
+		return Key{}, err
+	}
+
+	nk, err := newNamedKeyCell(a, name, uint32(k.Offset()), k.nk.securityKeyOffset, 0)
+	if err != nil {
+		return Key{}, err
+	}
+	err = k.addSubKey(nk, name)
+	if err != nil {
+		return Key{}, err
+	}
+	return k.registry.OpenKeyAt(int64(nk))
+}
+
+// addSubKey adds the named key at offset nk to the subkeys of k
+func (k Key) addSubKey(nk uint32, name string) error {
+	list := uint32(noOffset)
+	if k.nk.numberOfSubKeys > 0 {
+		list = k.nk.subKeysListOffset
+	}
+
+	var err error
+	k.nk.subKeysListOffset, err = insertSubKey(k.registry.cells, list, nk, name)
+	if err != nil {
+		return err
+	}
+	k.nk.numberOfSubKeys++
+	if n := utf16Size(name); n > k.nk.largestSubKeyNameSize {
+		k.nk.largestSubKeyNameSize = n
+	}
+	return k.writeNamedKey()
+}
+
+// validKeyName reports whether name can be the name of a key
+func validKeyName(name string) bool {
+	return name != "" && len([]rune(name)) <= maxKeyNameLength && !strings.ContainsRune(name, separator)
+}
+
+// newNamedKeyCell allocates and writes a "nk" cell without subkeys nor values
+func newNamedKeyCell(a *cellAllocator, name string, parent, security uint32, flags uint16) (uint32, error) {
+	n, compressed := encodeName(name)
+	if compressed {
+		flags |= nk_KEY_COMP_NAME
+	}
+
+	b := make([]byte, 76+len(n))
+	copy(b, namedKeySig)
+	binary.LittleEndian.PutUint16(b[2:4], flags)
+	binary.LittleEndian.PutUint64(b[4:12], filetime(time.Now()))
+	binary.LittleEndian.PutUint32(b[16:20], parent)
+	binary.LittleEndian.PutUint32(b[28:32], noOffset) // subkeys list
+	binary.LittleEndian.PutUint32(b[32:36], noOffset) // volatile subkeys list
+	binary.LittleEndian.PutUint32(b[40:44], noOffset) // values list
+	binary.LittleEndian.PutUint32(b[44:48], security)
+	binary.LittleEndian.PutUint32(b[48:52], noOffset) // class name
+	binary.LittleEndian.PutUint16(b[72:74], uint16(len(n)))
+	copy(b[76:], n)
+
+	nk, err := a.alloc(len(b))
+	if err != nil {
+		return 0, err
+	}
+	return nk, a.writeCell(nk, b)
+}
+
+// setClassName replaces the class name of k
+func (k Key) setClassName(class string) error {
+	a := k.registry.cells
+	if k.nk.classNameOffset != noOffset && k.nk.classNameSize > 0 {
+		err := a.free(k.nk.classNameOffset)
+		if err != nil {
+			return err
+		}
+	}
+	k.nk.classNameOffset, k.nk.classNameSize = noOffset, 0
+
+	b := utf16LEFromString(class)
+	if len(b) > 0 {
+		off, err := a.alloc(len(b))
+		if err != nil {
+			return err
+		}
+		err = a.writeCell(off, b)
+		if err != nil {
+			return err
+		}
+		k.nk.classNameOffset, k.nk.classNameSize = off, uint16(len(b))
+	}
+	err := k.writeNamedKey()
+	if err != nil || k.nk.isRoot() {
+		return err
+	}
+
+	parent, err := k.Parent()
+	if err != nil {
+		return err
+	}
+	if n := uint32(len(b)); n > parent.nk.largestSubKeyClassNameSize {
+		parent.nk.largestSubKeyClassNameSize = n
+		return parent.writeNamedKey()
+	}
+	return nil
+}
+
+// freeNamedKey frees the cells of nk: values, class name, security reference and nk itself
+func freeNamedKey(a *cellAllocator, nk *namedKey) error {
+	for _, vk := range nk.values.offsets {
+		err := freeValueKey(a, vk)
+		if err != nil {
+			return err
+		}
+	}
+	if nk.numberOfValues > 0 {
+		err := a.free(nk.valuesListOffset)
+		if err != nil {
+			return err
+		}
+	}
+	if nk.classNameOffset != noOffset && nk.classNameSize > 0 {
+		err := a.free(nk.classNameOffset)
+		if err != nil {
+			return err
+		}
+	}
+	err := releaseSecurityKey(a, nk.securityKeyOffset)
+	if err != nil {
+		return err
+	}
+	return a.free(uint32(nk.fpOffset - nk.binOffset))
+}
+
+// retainSecurityKey increments the reference count of the "sk" cell at offset
+func retainSecurityKey(a *cellAllocator, offset uint32) error {
+	b := make([]byte, 16)
+	err := a.readCell(offset, b)
+	if err != nil {
+		return err
+	}
+	if string(b[:2]) != securityKeySig {
+		return errorW{err: ErrCorruptRegistry, cause: errBadSignature, function: "retainSecurityKey"}
+	}
+	binary.LittleEndian.PutUint32(b[12:16], binary.LittleEndian.Uint32(b[12:16])+1)
+	return a.writeCell(offset, b)
+}
+
+// releaseSecurityKey decrements the reference count of the "sk" cell at offset.
+// When no key references it, it is removed from the list of "sk" cells and freed
+func releaseSecurityKey(a *cellAllocator, offset uint32) error {
+	b := make([]byte, 16)
+	err := a.readCell(offset, b)
+	if err != nil {
+		return err
+	}
+	if string(b[:2]) != securityKeySig {
+		return errorW{err: ErrCorruptRegistry, cause: errBadSignature, function: "releaseSecurityKey"}
+	}
+
+	refs := binary.LittleEndian.Uint32(b[12:16])
+	if refs > 1 {
+		binary.LittleEndian.PutUint32(b[12:16], refs-1)
+		return a.writeCell(offset, b)
+	}
+
+	prev := binary.LittleEndian.Uint32(b[4:8])
+	next := binary.LittleEndian.Uint32(b[8:12])
+	if prev != offset {
+		link := make([]byte, 4)
+		binary.LittleEndian.PutUint32(link, next)
+		err = a.writeAt(prev+cellHeaderSize+8, link)
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(link, prev)
+		err = a.writeAt(next+cellHeaderSize+4, link)
+		if err != nil {
+			return err
+		}
+	}
+	return a.free(offset)
+}
+
+// subKeyEntry is an element of a subkey list
+type subKeyEntry struct {
+	offset uint32 // named key, or subkey list for index roots
+	name   string // named key name, or name of the last key of the subkey list
+}
+
+// readSubKeyList reads the elements of the subkey list at offset.
+// For index roots the name is the name of the last key of each list
+func readSubKeyList(a *cellAllocator, offset uint32) (sig string, entries []subKeyEntry, err error) {
+	b := make([]byte, 4)
+	err = a.readCell(offset, b)
+	if err != nil {
+		return
+	}
+	sig = string(b[:2])
+	n := int(binary.LittleEndian.Uint16(b[2:4]))
+
+	size := 8
+	if sig == subKeyList3Sig || sig == subKeyList4Sig {
+		size = 4
+	}
+	b = make([]byte, 4+n*size)
+	err = a.readCell(offset, b)
+	if err != nil {
+		return
+	}
+
+	entries = make([]subKeyEntry, n)
+	for i := range entries {
+		entries[i].offset = binary.LittleEndian.Uint32(b[4+i*size:])
+		if sig == subKeyList4Sig {
+			_, leaf, err := readSubKeyList(a, entries[i].offset)
+			if err != nil {
+				return "", nil, err
+			}
+			if len(leaf) > 0 {
+				entries[i].name = leaf[len(leaf)-1].name
+			}
+			continue
+		}
+		entries[i].name, err = readNamedKeyName(a, entries[i].offset)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return sig, entries, nil
+}
+
+// writeSubKeyList writes the subkey list at offset, moving it if it
+// does not fit, and returns its offset. If offset is noOffset a new
+// list is allocated
+func writeSubKeyList(a *cellAllocator, offset uint32, sig string, entries []subKeyEntry) (uint32, error) {
+	size := 8
+	if sig == subKeyList3Sig || sig == subKeyList4Sig {
+		size = 4
+	}
+
+	b := make([]byte, 4+len(entries)*size)
+	copy(b, sig)
+	binary.LittleEndian.PutUint16(b[2:4], uint16(len(entries)))
+	for i, e := range entries {
+		binary.LittleEndian.PutUint32(b[4+i*size:], e.offset)
+		switch sig {
+		case subKeyList1Sig:
+			binary.LittleEndian.PutUint32(b[8+i*size:], lfSubKeyHash(e.name))
+		case subKeyList2Sig:
+			binary.LittleEndian.PutUint32(b[8+i*size:], lhSubKeyHash(e.name))
+		}
+	}
+
+	var err error
+	if offset == noOffset {
+		offset, err = a.alloc(len(b))
+	} else {
+		offset, err = a.realloc(offset, len(b))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return offset, a.writeCell(offset, b)
+}
+
+// readNamedKeyName reads the name of the "nk" cell at offset
+func readNamedKeyName(a *cellAllocator, offset uint32) (string, error) {
+	b := make([]byte, 76)
+	err := a.readCell(offset, b)
+	if err != nil {
+		return "", err
+	}
+	flags := binary.LittleEndian.Uint16(b[2:4])
+	name := make([]byte, binary.LittleEndian.Uint16(b[72:74]))
+	err = a.readAt(offset+cellHeaderSize+76, name)
+	if err != nil {
+		return "", err
+	}
+	return decodeName(name, flags&nk_KEY_COMP_NAME != 0), nil
+}
+
+// compareKeyNames compares names as Windows sorts subkey lists: the
+// UTF-16 code units of the names, upcased one by one
+func compareKeyNames(a, b string) int {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		ca, cb := upcaseUnit(ua[i]), upcaseUnit(ub[i])
+		switch {
+		case ca < cb:
+			return -1
+		case ca > cb:
+			return 1
+		}
+	}
+	switch {
+	case len(ua) < len(ub):
+		return -1
+	case len(ua) > len(ub):
+		return 1
+	}
+	return 0
+}
+
+// upcaseUnit returns the upper case of UTF-16 code unit c, surrogates
+// and characters whose upper case is out of the BMP are kept
+func upcaseUnit(c uint16) uint16 {
+	if utf16.IsSurrogate(rune(c)) {
+		return c
+	}
+	u := unicode.ToUpper(rune(c))
+	if u > 0xffff {
+		return c
+	}
+	return uint16(u)
+}
+
+// insertSubKey inserts the named key at offset nk in the subkey list
+// at offset list, keeping it sorted, and returns the new list offset.
+// Lists with more than maxLeafElements elements are split and
+// referenced by an index root.
+func insertSubKey(a *cellAllocator, list, nk uint32, name string) (uint32, error) {
+	e := subKeyEntry{offset: nk, name: name}
+	if list == noOffset {
+		// hash leaves ("lh") are used since version 1.5
+		sig := subKeyList2Sig
+		if a.header.minor < 5 {
+			sig = subKeyList1Sig
+		}
+		return writeSubKeyList(a, noOffset, sig, []subKeyEntry{e})
+	}
+
+	sig, entries, err := readSubKeyList(a, list)
+	if err != nil {
+		return 0, err
+	}
+	if sig != subKeyList4Sig {
+		leaves, err := insertLeaf(a, list, sig, entries, e)
+		if err != nil {
+			return 0, err
+		}
+		if len(leaves) == 1 {
+			return leaves[0].offset, nil
+		}
+		return writeSubKeyList(a, noOffset, subKeyList4Sig, leaves)
+	}
+
+	// insert in the first leaf whose last key is after name, or in the last one
+	i := sort.Search(len(entries), func(i int) bool { return compareKeyNames(entries[i].name, name) >= 0 })
+	if i == len(entries) {
+		i--
+	}
+	leafSig, leaf, err := readSubKeyList(a, entries[i].offset)
+	if err != nil {
+		return 0, err
+	}
+	leaves, err := insertLeaf(a, entries[i].offset, leafSig, leaf, e)
+	if err != nil {
+		return 0, err
+	}
+	entries = append(entries[:i], append(leaves, entries[i+1:]...)...)
+	return writeSubKeyList(a, list, subKeyList4Sig, entries)
+}
+
+// insertLeaf inserts e in the leaf list at offset list and returns
+// the resulting lists, two if the list had to be split
+func insertLeaf(a *cellAllocator, list uint32, sig string, entries []subKeyEntry, e subKeyEntry) ([]subKeyEntry, error) {
+	i := sort.Search(len(entries), func(i int) bool { return compareKeyNames(entries[i].name, e.name) >= 0 })
+	entries = append(entries, subKeyEntry{})
+	copy(entries[i+1:], entries[i:])
+	entries[i] = e
+
+	if len(entries) <= maxLeafElements {
+		off, err := writeSubKeyList(a, list, sig, entries)
+		return []subKeyEntry{{offset: off, name: entries[len(entries)-1].name}}, err
+	}
+
+	half := len(entries) / 2
+	first, err := writeSubKeyList(a, list, sig, entries[:half])
+	if err != nil {
+		return nil, err
+	}
+	second, err := writeSubKeyList(a, noOffset, sig, entries[half:])
+	if err != nil {
+		return nil, err
+	}
+	return []subKeyEntry{
+		{offset: first, name: entries[half-1].name},
+		{offset: second, name: entries[len(entries)-1].name},
+	}, nil
+}
+
+// removeSubKey removes the named key at offset nk from the subkey list
+// at offset list and returns the new list offset, noOffset if the list is empty
+func removeSubKey(a *cellAllocator, list, nk uint32) (uint32, error) {
+	sig, entries, err := readSubKeyList(a, list)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, e := range entries {
+		if sig == subKeyList4Sig {
+			leaf, err := removeSubKey(a, e.offset, nk)
+			if err == ErrNotExist {
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+			if leaf != noOffset {
+				entries[i].offset = leaf
+				return writeSubKeyList(a, list, sig, entries)
+			}
+		} else if e.offset != nk {
+			continue
+		}
+
+		entries = append(entries[:i], entries[i+1:]...)
+		if len(entries) == 0 {
+			return noOffset, a.free(list)
+		}
+		return writeSubKeyList(a, list, sig, entries)
+	}
+	return 0, ErrNotExist
+}
@@ -2,7 +2,10 @@ package registry
 
 import (
 	"encoding/hex"
+	"io"
+	"os"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 )
@@ -61,7 +64,8 @@ func TestKey_ReadValueNames(t *testing.T) {
 		{name: `testdata/NTUSER.DAT SOFTWARE\Microsoft\CTF\Assemblies\0x00000816\{34745C63-B2F0-4784-8B67-5E12C8701A31}`, args: args{filename: "testdata/NTUSER.DAT", path: `SOFTWARE\Microsoft\CTF\Assemblies\0x00000816\{34745C63-B2F0-4784-8B67-5E12C8701A31}`, n: -1}, want: []string{"Default", "KeyboardLayout", "Profile"}},
 		{name: `testdata/NTUSER.DAT SOFTWARE\Microsoft\CTF\Assemblies\0x00000816\{34745C63-B2F0-4784-8B67-5E12C8701A31}`, args: args{filename: "testdata/NTUSER.DAT", path: `SOFTWARE\Microsoft\CTF\Assemblies\0x00000816\{34745C63-B2F0-4784-8B67-5E12C8701A31}`, n: 1}, want: []string{"Default"}},
 		{name: `testdata/NTUSER.DAT SOFTWARE\Microsoft\CTF\Assemblies\0x00000816\{34745C63-B2F0-4784-8B67-5E12C8701A31}`, args: args{filename: "testdata/NTUSER.DAT", path: `SOFTWARE\Microsoft\CTF\Assemblies\0x00000816\{34745C63-B2F0-4784-8B67-5E12C8701A31}`, n: 5}, want: []string{"Default", "KeyboardLayout", "Profile"}},
-		{name: `testdata/NTUSER.DAT`, args: args{filename: "testdata/NTUSER.DAT", path: ``, n: 5}, want: []string{}},
+		{name: `testdata/NTUSER.DAT`, args: args{filename: "testdata/NTUSER.DAT", path: ``, n: 5}, want: []string{}, wantErr: true},
+		{name: `testdata/NTUSER.DAT`, args: args{filename: "testdata/NTUSER.DAT", path: ``, n: -1}, want: []string{}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -372,3 +376,323 @@ func TestKey_GetBinaryValue(t *testing.T) {
 		})
 	}
 }
+
+func TestKey_ReadValues(t *testing.T) {
+	k, err := OpenKey("testdata/NTUSER.DAT", `SOFTWARE\Microsoft\CTF\Assemblies\0x00000816\{34745C63-B2F0-4784-8B67-5E12C8701A31}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	var names []string
+	for {
+		values, err := k.ReadValues(2)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Key.ReadValues() error = %v", err)
+		}
+		if len(values) == 0 || len(values) > 2 {
+			t.Fatalf("Key.ReadValues() returned %v values", len(values))
+		}
+		for _, v := range values {
+			names = append(names, v.Name)
+		}
+	}
+	sort.Strings(names)
+	if want := []string{"Default", "KeyboardLayout", "Profile"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Key.ReadValues() names = %v, want %v", names, want)
+	}
+
+	values, err := k.ReadValues(-1)
+	if err != nil || len(values) != 0 {
+		t.Errorf("Key.ReadValues(-1) at end = %v, %v, want [], nil", values, err)
+	}
+
+	k, err = OpenKey("testdata/NTUSER.DAT", `Control Panel\International\User Profile`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	values, err = k.ReadValues(0)
+	if err != nil {
+		t.Fatalf("Key.ReadValues() error = %v", err)
+	}
+	for _, v := range values {
+		if v.Name != "Languages" {
+			continue
+		}
+		if v.Type != REG_MULTI_SZ || !reflect.DeepEqual(v.Strings(), []string{"pt-PT"}) {
+			t.Errorf("Languages = %v %v, want REG_MULTI_SZ [pt-PT]", Type(v.Type), v.Strings())
+		}
+		if want := append(utf16LEFromString("pt-PT"), 0, 0, 0, 0); !reflect.DeepEqual(v.Bytes(), want) {
+			t.Errorf("Languages raw = %v, want %v", v.Bytes(), want)
+		}
+		return
+	}
+	t.Errorf("Key.ReadValues() did not return Languages")
+}
+
+func TestKey_ReadSubKeyNames_paging(t *testing.T) {
+	k, err := OpenKey("testdata/NTUSER.DAT", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	want, err := k.ReadSubKeyNames(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err = OpenKey("testdata/NTUSER.DAT", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	var got []string
+	for {
+		names, err := k.ReadSubKeyNames(2)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Key.ReadSubKeyNames() error = %v", err)
+		}
+		got = append(got, names...)
+	}
+	if !reflect.DeepEqual(got, want) || !sort.StringsAreSorted(got) {
+		t.Errorf("Key.ReadSubKeyNames() pages = %v, want %v", got, want)
+	}
+}
+
+func TestKey_Read_toEOF(t *testing.T) {
+	k, err := OpenKey("testdata/NTUSER.DAT", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	// keys of the iterator, copies of keys and the zero keys all end
+	it := k.SubKeys()
+	for it.Next() {
+		sub := it.Key()
+		for _, read := range []func() (int, error){
+			func() (int, error) { names, err := sub.ReadSubKeyNames(1); return len(names), err },
+			func() (int, error) { names, err := sub.ReadValueNames(1); return len(names), err },
+			func() (int, error) { values, err := sub.ReadValues(1); return len(values), err },
+		} {
+			for i := 0; ; i++ {
+				n, err := read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil || n != 1 || i > 1000 {
+					t.Fatalf("reading %v page %v = %v, %v", sub.Name(), i, n, err)
+				}
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := (Key{}).ReadSubKeyNames(1); err != ErrNotExist {
+		t.Errorf("Key{}.ReadSubKeyNames() error = %v, want %v", err, ErrNotExist)
+	}
+	if _, err := (MergedKey{}).ReadSubKeyNames(1); err != io.EOF {
+		t.Errorf("MergedKey{}.ReadSubKeyNames() error = %v, want %v", err, io.EOF)
+	}
+	if _, err := (MergedKey{}).ReadValues(1); err != io.EOF {
+		t.Errorf("MergedKey{}.ReadValues() error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestKey_SubKeys(t *testing.T) {
+	k, err := OpenKey("testdata/NTUSER.DAT", "SOFTWARE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	var names []string
+	it := k.SubKeys()
+	for it.Next() {
+		sk := it.Key()
+		names = append(names, sk.nk.name)
+		if _, err := sk.ReadValueNames(-1); err != nil {
+			t.Errorf("ReadValueNames() on %v error = %v", sk.nk.name, err)
+		}
+	}
+	if it.Err() != nil {
+		t.Fatalf("SubKeyIterator.Err() = %v", it.Err())
+	}
+	if want := []string{"Google", "Microsoft", "Policies"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Key.SubKeys() = %v, want %v", names, want)
+	}
+}
+
+func TestKey_Path(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		wantName   string
+		wantParent string
+	}{
+		{name: "root", path: ``, wantName: "ROOT", wantParent: ""},
+		{name: "first level", path: `SOFTWARE`, wantName: "SOFTWARE", wantParent: ""},
+		{name: "deep", path: `SOFTWARE\Google\Chrome\NativeMessagingHosts\com.microsoft.browsercore`, wantName: "com.microsoft.browsercore", wantParent: `SOFTWARE\Google\Chrome\NativeMessagingHosts`},
+		{name: "case insensitive open", path: `software\google`, wantName: "Google", wantParent: "SOFTWARE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := OpenKey("testdata/NTUSER.DAT", tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer k.Close()
+
+			if got := k.Name(); got != tt.wantName {
+				t.Errorf("Key.Name() = %v, want %v", got, tt.wantName)
+			}
+
+			got, err := k.Path()
+			if err != nil {
+				t.Fatalf("Key.Path() error = %v", err)
+			}
+			if !strings.EqualFold(got, tt.path) {
+				t.Errorf("Key.Path() = %v, want %v", got, tt.path)
+			}
+
+			p, err := k.Parent()
+			if tt.path == "" {
+				if err != ErrNotExist {
+					t.Errorf("Key.Parent() on root error = %v, want %v", err, ErrNotExist)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Key.Parent() error = %v", err)
+			}
+			got, err = p.Path()
+			if err != nil || got != tt.wantParent {
+				t.Errorf("Key.Parent().Path() = %v, %v, want %v", got, err, tt.wantParent)
+			}
+		})
+	}
+}
+
+func TestKey_Path_loop(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	parent, err := r.OpenKey("Control Panel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := parent.OpenSubKey("Desktop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Control Panel and Desktop are the parents of each other
+	parent.nk.parentKeyOffset = uint32(k.Offset())
+	if err := parent.nk.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Path(); err != ErrCorruptRegistry {
+		t.Errorf("Key.Path() of a parent loop error = %v, want %v", err, ErrCorruptRegistry)
+	}
+}
+
+func TestRegistry_OpenKeyAt(t *testing.T) {
+	r, err := Open("testdata/NTUSER.DAT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	root, err := r.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Offset() != int64(r.header.rootOffset) {
+		t.Errorf("root Key.Offset() = %#x, want %#x", root.Offset(), r.header.rootOffset)
+	}
+
+	k, err := r.OpenKey(`SOFTWARE\Google\Chrome`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.OpenKeyAt(k.Offset())
+	if err != nil {
+		t.Fatalf("Registry.OpenKeyAt() error = %v", err)
+	}
+	if !got.Equal(k) || got.Name() != "Chrome" {
+		t.Errorf("Registry.OpenKeyAt() = %v, want %v", got.Name(), k.Name())
+	}
+	if got.Equal(root) {
+		t.Errorf("Key.Equal() of different keys returned true")
+	}
+
+	other, err := Open("testdata/NTUSER.DAT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	ok, err := other.OpenKeyAt(k.Offset())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok.Equal(k) {
+		t.Errorf("Key.Equal() of keys of different registries returned true")
+	}
+
+	for _, offset := range []int64{-8, 0, k.Offset() + 4, k.Offset() + 8, int64(r.header.binSize)} {
+		if _, err := r.OpenKeyAt(offset); err != ErrInvalidOffset {
+			t.Errorf("Registry.OpenKeyAt(%#x) error = %v, want %v", offset, err, ErrInvalidOffset)
+		}
+	}
+}
+
+func TestRegistry_OpenKeyAt_unaligned(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	k, err := r.OpenKey("Environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a copy of the cell of the key in value data, 4 bytes after its cell
+	cell := make([]byte, 128)
+	if _, err := r.rws.Seek(hiveBinsOffset+k.Offset(), io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(r.rws, cell); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.SetBinaryValue("Cell", cell); err != nil {
+		t.Fatal(err)
+	}
+	vk, err := k.getValue("Cell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.OpenKeyAt(int64(vk.dataOffset) + cellHeaderSize); err != ErrInvalidOffset {
+		t.Errorf("Registry.OpenKeyAt() of an unaligned offset error = %v, want %v", err, ErrInvalidOffset)
+	}
+}
@@ -0,0 +1,233 @@
+package registry
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// MergedKey is a view of keys of different hives as a single key, like
+// HKEY_CLASSES_ROOT merges HKEY_CURRENT_USER\Software\Classes with
+// HKEY_LOCAL_MACHINE\SOFTWARE\Classes. Subkeys and values are merged,
+// a value or the spelling of a name is taken from the first key having it.
+type MergedKey struct {
+	keys []Key // in order of precedence
+
+	cursor *keyCursor // shared by copies of the key, like Key's
+}
+
+// NewMergedKey returns the view of keys, in order of precedence.
+// Keys not opened, the zero Key, are skipped.
+func NewMergedKey(keys ...Key) MergedKey {
+	m := MergedKey{cursor: &keyCursor{}}
+	for _, k := range keys {
+		if k.nk != nil {
+			m.keys = append(m.keys, k)
+		}
+	}
+	return m
+}
+
+// Keys returns the keys merged by m, in order of precedence
+func (m MergedKey) Keys() []Key {
+	return append([]Key{}, m.keys...)
+}
+
+// Name returns the name of the first key of m
+func (m MergedKey) Name() string {
+	if len(m.keys) == 0 {
+		return ""
+	}
+	return m.keys[0].Name()
+}
+
+// OpenSubKey opens the subkey path of every key of m and merges them.
+// If none of the keys has the subkey, OpenSubKey returns ErrNotExist.
+// access is the one of Key.OpenSubKey.
+func (m MergedKey) OpenSubKey(path string, access ...uint32) (MergedKey, error) {
+	var subs []Key
+	for _, k := range m.keys {
+		sub, err := k.OpenSubKey(path, access...)
+		if err == ErrNotExist {
+			continue
+		}
+		if err != nil {
+			return MergedKey{}, err
+		}
+		subs = append(subs, sub)
+	}
+	if len(subs) == 0 {
+		return MergedKey{}, ErrNotExist
+	}
+	return NewMergedKey(subs...), nil
+}
+
+// ReadSubKeyNames returns the names of the subkeys of the keys of m,
+// sorted and without duplicates, like Key.ReadSubKeyNames
+func (m MergedKey) ReadSubKeyNames(n int) ([]string, error) {
+	if m.cursor == nil {
+		m = NewMergedKey() // the zero MergedKey, without keys
+	}
+	if m.cursor.subKeyNames == nil {
+		names, err := m.mergeNames(Key.ReadSubKeyNames)
+		if err != nil {
+			return nil, err
+		}
+		m.cursor.subKeyNames = names
+	}
+	return nextNames(&m.cursor.subKeyNames, n)
+}
+
+// ReadValueNames returns the names of the values of the keys of m,
+// sorted and without duplicates, like Key.ReadValueNames
+func (m MergedKey) ReadValueNames(n int) ([]string, error) {
+	if m.cursor == nil {
+		m = NewMergedKey() // the zero MergedKey, without keys
+	}
+	if m.cursor.valueNames == nil {
+		names, err := m.mergeNames(Key.ReadValueNames)
+		if err != nil {
+			return nil, err
+		}
+		m.cursor.valueNames = names
+	}
+	return nextNames(&m.cursor.valueNames, n)
+}
+
+// mergeNames returns the names read by read from the keys of m, sorted
+// in the order of the registry, keeping the first of the names differing
+// only in case
+func (m MergedKey) mergeNames(read func(Key, int) ([]string, error)) ([]string, error) {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, k := range m.keys {
+		list, err := read(newKey(k.registry, k.rws, k.nk), -1) // keep the cursor of k
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range list {
+			if upper := strings.ToUpper(name); !seen[upper] {
+				seen[upper] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool { return compareKeyNames(names[i], names[j]) < 0 })
+	return names, nil
+}
+
+// ReadValues returns the values of m, like Key.ReadValues: the values
+// of the first key, followed by the values of the next keys that are
+// not found in the previous ones.
+func (m MergedKey) ReadValues(n int) ([]Value, error) {
+	if m.cursor == nil {
+		m = NewMergedKey() // the zero MergedKey, without keys
+	}
+
+	if m.cursor.mergedValues == nil {
+		values := []Value{}
+		seen := map[string]bool{}
+		for _, k := range m.keys {
+			list, err := newKey(k.registry, k.rws, k.nk).ReadValues(-1)
+			if err != nil {
+				return nil, err
+			}
+			for _, v := range list {
+				if upper := strings.ToUpper(v.Name); !seen[upper] {
+					seen[upper] = true
+					values = append(values, v)
+				}
+			}
+		}
+		m.cursor.mergedValues = values
+	}
+
+	left := m.cursor.mergedValues
+	if n > 0 && len(left) == 0 {
+		return []Value{}, io.EOF
+	}
+	if n <= 0 || n > len(left) {
+		n = len(left)
+	}
+	m.cursor.mergedValues = left[n:]
+	return left[:n:n], nil
+}
+
+// valueKey returns the first key of m having value name
+func (m MergedKey) valueKey(name string) (Key, error) {
+	for _, k := range m.keys {
+		_, err := k.getValue(name)
+		if err == nil {
+			return k, nil
+		}
+		if err != ErrNotExist {
+			return Key{}, err
+		}
+	}
+	return Key{}, ErrNotExist
+}
+
+// GetValue retrieves the type and data of value name, like Key.GetValue
+func (m MergedKey) GetValue(name string, buf []byte) (n int, valtype uint32, err error) {
+	k, err := m.valueKey(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	return k.GetValue(name, buf)
+}
+
+// GetBinaryValue retrieves the binary value name, like Key.GetBinaryValue
+func (m MergedKey) GetBinaryValue(name string) (val []byte, valtype uint32, err error) {
+	k, err := m.valueKey(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	return k.GetBinaryValue(name)
+}
+
+// GetIntegerValue retrieves the integer value name, like Key.GetIntegerValue
+func (m MergedKey) GetIntegerValue(name string) (val uint64, valtype uint32, err error) {
+	k, err := m.valueKey(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	return k.GetIntegerValue(name)
+}
+
+// GetStringValue retrieves the string value name, like Key.GetStringValue
+func (m MergedKey) GetStringValue(name string) (val string, valtype uint32, err error) {
+	k, err := m.valueKey(name)
+	if err != nil {
+		return "", 0, err
+	}
+	return k.GetStringValue(name)
+}
+
+// GetStringsValue retrieves the []string value name, like Key.GetStringsValue
+func (m MergedKey) GetStringsValue(name string) (val []string, valtype uint32, err error) {
+	k, err := m.valueKey(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	return k.GetStringsValue(name)
+}
+
+// GetExpandedStringValue retrieves the string value name expanded
+// with env, like Key.GetExpandedStringValue
+func (m MergedKey) GetExpandedStringValue(name string, env *Environment) (val string, valtype uint32, err error) {
+	k, err := m.valueKey(name)
+	if err != nil {
+		return "", 0, err
+	}
+	return k.GetExpandedStringValue(name, env)
+}
+
+// GetMUIStringValueWith retrieves the localized string value name,
+// like Key.GetMUIStringValueWith
+func (m MergedKey) GetMUIStringValueWith(name string, r *MUIResolver) (string, error) {
+	k, err := m.valueKey(name)
+	if err != nil {
+		return "", err
+	}
+	return k.GetMUIStringValueWith(name, r)
+}
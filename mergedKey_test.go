@@ -0,0 +1,151 @@
+package registry
+
+import (
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testHive creates the registry file name, filled by fill
+func testHive(t testing.TB, name string, fill func(Key) error) Registry {
+	r, err := Create(name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := r.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fill(k); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// testClasses creates the keys at paths under k, setting their default value to their path
+func testClasses(k Key, paths ...string) error {
+	for _, path := range paths {
+		sub, _, err := k.CreateKey(path)
+		if err != nil {
+			return err
+		}
+		if err = sub.SetStringValue("", path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestMergedKey(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+	dir := filepath.Dir(file)
+
+	user := testHive(t, filepath.Join(dir, "UsrClass.dat"), func(k Key) error {
+		return testClasses(k, `.txt\OpenWithProgids`, `.txt`, `.user`, `.Zip`, `Wow6432Node\CLSID\{A}`)
+	})
+	defer user.Close()
+	machine := testHive(t, filepath.Join(dir, "SOFTWARE"), func(k Key) error {
+		err := testClasses(k, `.TXT\ShellNew`, `.TXT`, `.machine`, `.apk`, `Wow6432Node\CLSID\{A}`, `Wow6432Node\CLSID\{B}`)
+		if err != nil {
+			return err
+		}
+		txt, err := k.OpenSubKey(".TXT")
+		if err != nil {
+			return err
+		}
+		return txt.SetStringValue("Content Type", "text/plain")
+	})
+	defer machine.Close()
+
+	u, err := user.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := machine.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := NewMergedKey(u, Key{}, m)
+	if got := len(root.Keys()); got != 2 {
+		t.Errorf("MergedKey.Keys() has %v keys, want 2", got)
+	}
+
+	names, err := root.ReadSubKeyNames(-1)
+	if want := []string{".apk", ".machine", ".txt", ".user", ".Zip", "Wow6432Node"}; err != nil || !reflect.DeepEqual(names, want) {
+		t.Errorf("MergedKey.ReadSubKeyNames() = %v, %v, want %v", names, err, want)
+	}
+
+	txt, err := root.OpenSubKey(".txt")
+	if err != nil {
+		t.Fatalf("MergedKey.OpenSubKey() error = %v", err)
+	}
+	if got, _, err := txt.GetStringValue(""); err != nil || got != ".txt" {
+		t.Errorf("MergedKey.GetStringValue() = %v, %v, want the value of the user", got, err)
+	}
+	if got, _, err := txt.GetStringValue("content type"); err != nil || got != "text/plain" {
+		t.Errorf("MergedKey.GetStringValue() = %v, %v, want the value of the machine", got, err)
+	}
+	if _, _, err := txt.GetIntegerValue("Content Type"); err != ErrUnexpectedType {
+		t.Errorf("MergedKey.GetIntegerValue() error = %v, want %v", err, ErrUnexpectedType)
+	}
+	if _, _, err := txt.GetStringValue("Missing"); err != ErrNotExist {
+		t.Errorf("MergedKey.GetStringValue() error = %v, want %v", err, ErrNotExist)
+	}
+	if got, err := txt.ReadValueNames(-1); err != nil || !reflect.DeepEqual(got, []string{defaultValueName, "Content Type"}) {
+		t.Errorf("MergedKey.ReadValueNames() = %v, %v", got, err)
+	}
+	if got, err := txt.ReadSubKeyNames(-1); err != nil || !reflect.DeepEqual(got, []string{"OpenWithProgids", "ShellNew"}) {
+		t.Errorf("MergedKey.ReadSubKeyNames() = %v, %v", got, err)
+	}
+
+	var values []Value
+	for {
+		v, err := txt.ReadValues(1)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("MergedKey.ReadValues() error = %v", err)
+		}
+		values = append(values, v...)
+	}
+	if len(values) != 2 || values[0].String() != ".txt" || values[1].Name != "Content Type" {
+		t.Errorf("MergedKey.ReadValues() = %v", values)
+	}
+
+	// values deleted between pages are still returned
+	txt, err = root.OpenSubKey(".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := txt.ReadValues(1); err != nil {
+		t.Fatalf("MergedKey.ReadValues() error = %v", err)
+	}
+	if err := txt.Keys()[1].DeleteValue("Content Type"); err != nil {
+		t.Fatal(err)
+	}
+	if values, err := txt.ReadValues(-1); err != nil || len(values) != 1 || values[0].Name != "Content Type" {
+		t.Errorf("MergedKey.ReadValues() after a deletion = %v, %v", values, err)
+	}
+
+	clsid, err := root.OpenSubKey(`Wow6432Node\CLSID`)
+	if err != nil {
+		t.Fatalf("MergedKey.OpenSubKey() error = %v", err)
+	}
+	if got, err := clsid.ReadSubKeyNames(-1); err != nil || !reflect.DeepEqual(got, []string{"{A}", "{B}"}) {
+		t.Errorf("MergedKey.ReadSubKeyNames() = %v, %v", got, err)
+	}
+	a, err := clsid.OpenSubKey("{A}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, err := a.GetStringValue(""); err != nil || got != `Wow6432Node\CLSID\{A}` || len(a.Keys()) != 2 {
+		t.Errorf("MergedKey.GetStringValue() = %v, %v", got, err)
+	}
+
+	if _, err := root.OpenSubKey(".missing"); err != ErrNotExist {
+		t.Errorf("MergedKey.OpenSubKey() error = %v, want %v", err, ErrNotExist)
+	}
+}
@@ -0,0 +1,305 @@
+package registry
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	rtString = 6 // RT_STRING resource type
+
+	imageDirectoryEntryResource = 2 // IMAGE_DIRECTORY_ENTRY_RESOURCE data directory
+)
+
+// MUIResolver resolves indirect strings like
+// "@%SystemRoot%\system32\shell32.dll,-21787" against the files
+// of an offline Windows installation.
+type MUIResolver struct {
+	// Root is the directory where the Windows volume is mounted
+	Root string
+
+	// Env expands the variables of the file path of the reference.
+	// If nil, SystemRoot is C:\Windows
+	Env *Environment
+
+	// Languages, like "en-US", are tried in order when looking for
+	// the .mui satellite of a file. If empty, "en-US" is used
+	Languages []string
+}
+
+// NewMUIResolver returns a resolver for the Windows volume mounted
+// at root. env and languages may be nil.
+func NewMUIResolver(root string, env *Environment, languages ...string) *MUIResolver {
+	return &MUIResolver{Root: root, Env: env, Languages: languages}
+}
+
+// Resolve returns the string referenced by s. Strings not starting
+// with "@" are returned unchanged. If the string can not be found,
+// or the reference is not supported (like "@{Package?ms-resource:...}"),
+// Resolve returns ErrNotExist.
+func (m *MUIResolver) Resolve(s string) (string, error) {
+	if !strings.HasPrefix(s, "@") {
+		return s, nil
+	}
+	file, id, err := parseMUIReference(s)
+	if err != nil {
+		return "", err
+	}
+
+	env := m.Env
+	if env == nil {
+		env = NewEnvironment()
+		env.Set("SystemDrive", "C:")
+		env.Set("SystemRoot", `C:\Windows`)
+		env.Set("windir", `%SystemRoot%`)
+	}
+	file, _ = env.ExpandString(file)
+	if !strings.ContainsAny(file, `\/`) {
+		file = `%SystemRoot%\System32\` + file
+		file, _ = env.ExpandString(file)
+	}
+	systemRoot, _ := env.Get("SystemRoot")
+
+	for _, candidate := range m.candidates(file, systemRoot) {
+		local, err := localPath(m.Root, candidate)
+		if err != nil {
+			continue
+		}
+		str, err := peString(local, id)
+		if err == nil {
+			return str, nil
+		}
+	}
+	return "", ErrNotExist
+}
+
+// candidates returns the windows paths where the string of file may be found
+func (m *MUIResolver) candidates(file, systemRoot string) []string {
+	langs := m.Languages
+	if len(langs) == 0 {
+		langs = []string{"en-US"}
+	}
+
+	i := strings.LastIndexAny(file, `\/`)
+	dir, base := file[:i], file[i+1:]
+
+	var c []string
+	for _, l := range langs {
+		c = append(c, dir+`\`+l+`\`+base+".mui")
+	}
+	if systemRoot != "" {
+		c = append(c, systemRoot+`\SystemResources\`+base+".mun")
+	}
+	return append(c, file)
+}
+
+// parseMUIReference parses "@file,-id;comment"
+func parseMUIReference(s string) (file string, id uint16, err error) {
+	s = strings.TrimPrefix(s, "@")
+	if strings.HasPrefix(s, "{") {
+		return "", 0, ErrNotExist
+	}
+	if i := strings.IndexByte(s, ';'); i >= 0 {
+		s = s[:i]
+	}
+	i := strings.LastIndexByte(s, ',')
+	if i < 0 {
+		return "", 0, ErrNotExist
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s[i+1:]), 10, 32)
+	if err != nil || n == 0 {
+		return "", 0, ErrNotExist
+	}
+	if n < 0 {
+		n = -n
+	}
+	if n > 0xffff {
+		return "", 0, ErrNotExist
+	}
+	return strings.TrimSpace(s[:i]), uint16(n), nil
+}
+
+// localPath maps the windows path p to a file under root, matching
+// each path element case insensitively
+func localPath(root, p string) (string, error) {
+	p = strings.Replace(p, "/", `\`, -1)
+	if len(p) >= 2 && p[1] == ':' {
+		p = p[2:]
+	}
+
+	local := root
+	for _, name := range strings.Split(p, `\`) {
+		if name == "" || name == "." {
+			continue
+		}
+		next := filepath.Join(local, name)
+		if _, err := os.Lstat(next); err != nil {
+			infos, err := ioutil.ReadDir(local)
+			if err != nil {
+				return "", err
+			}
+			next = ""
+			for _, fi := range infos {
+				if strings.EqualFold(fi.Name(), name) {
+					next = filepath.Join(local, fi.Name())
+					break
+				}
+			}
+			if next == "" {
+				return "", os.ErrNotExist
+			}
+		}
+		local = next
+	}
+	return local, nil
+}
+
+// peString returns the string id from the string table resources of PE file name
+func peString(name string, id uint16) (string, error) {
+	f, err := pe.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var dd pe.DataDirectory
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		if oh.NumberOfRvaAndSizes <= imageDirectoryEntryResource {
+			return "", ErrNotExist
+		}
+		dd = oh.DataDirectory[imageDirectoryEntryResource]
+	case *pe.OptionalHeader64:
+		if oh.NumberOfRvaAndSizes <= imageDirectoryEntryResource {
+			return "", ErrNotExist
+		}
+		dd = oh.DataDirectory[imageDirectoryEntryResource]
+	default:
+		return "", ErrNotExist
+	}
+	if dd.VirtualAddress == 0 {
+		return "", ErrNotExist
+	}
+
+	for _, s := range f.Sections {
+		size := s.VirtualSize
+		if s.Size > size {
+			size = s.Size
+		}
+		if dd.VirtualAddress < s.VirtualAddress || dd.VirtualAddress >= s.VirtualAddress+size {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			return "", err
+		}
+		return resourceString(data, s.VirtualAddress, dd.VirtualAddress-s.VirtualAddress, id)
+	}
+	return "", ErrNotExist
+}
+
+// resourceString returns string id from the resource directory found at
+// offset start of section data. The section is loaded at virtual address va.
+func resourceString(data []byte, va, start uint32, id uint16) (string, error) {
+	if start >= uint32(len(data)) {
+		return "", ErrNotExist
+	}
+	rsrc := data[start:]
+
+	// type, name and language levels
+	dir, err := resourceEntry(rsrc, 0, rtString, true)
+	if err != nil {
+		return "", err
+	}
+	dir, err = resourceEntry(rsrc, dir, uint32(id>>4)+1, true)
+	if err != nil {
+		return "", err
+	}
+	entry, err := resourceEntry(rsrc, dir, 0, false)
+	if err != nil {
+		return "", err
+	}
+
+	if int(entry)+16 > len(rsrc) {
+		return "", errorW{err: errBadResource, cause: errors.New("data entry out of the resource directory"), function: "resourceString"}
+	}
+	rva := binary.LittleEndian.Uint32(rsrc[entry:])
+	size := binary.LittleEndian.Uint32(rsrc[entry+4:])
+	if rva < va || int64(rva-va)+int64(size) > int64(len(data)) {
+		return "", errorW{err: errBadResource, cause: errors.New("string block out of the section"), function: "resourceString"}
+	}
+	block := data[rva-va : rva-va+size]
+
+	// a block holds 16 strings, each prefixed by its length in UTF-16 units
+	for i := uint16(0); ; i++ {
+		if len(block) < 2 {
+			return "", ErrNotExist
+		}
+		n := int(binary.LittleEndian.Uint16(block)) * 2
+		block = block[2:]
+		if n > len(block) {
+			return "", ErrNotExist
+		}
+		if i == id&15 {
+			if n == 0 {
+				return "", ErrNotExist
+			}
+			u := make([]uint16, n/2)
+			for j := range u {
+				u[j] = binary.LittleEndian.Uint16(block[2*j:])
+			}
+			return string(utf16.Decode(u)), nil
+		}
+		block = block[n:]
+	}
+}
+
+// resourceEntry looks up the entry with the given id in the resource
+// directory at offset dir of rsrc and returns the offset it points to.
+// If id is 0, the first entry is returned (used to pick the language).
+// subdir tells whether the entry is expected to point to another directory.
+func resourceEntry(rsrc []byte, dir, id uint32, subdir bool) (uint32, error) {
+	if int(dir)+16 > len(rsrc) {
+		return 0, ErrNotExist
+	}
+	named := uint32(binary.LittleEndian.Uint16(rsrc[dir+12:]))
+	ids := uint32(binary.LittleEndian.Uint16(rsrc[dir+14:]))
+
+	for i := uint32(0); i < named+ids; i++ {
+		off := dir + 16 + i*8
+		if int(off)+8 > len(rsrc) {
+			return 0, ErrNotExist
+		}
+		name := binary.LittleEndian.Uint32(rsrc[off:])
+		target := binary.LittleEndian.Uint32(rsrc[off+4:])
+
+		if id != 0 && (name&0x80000000 != 0 || name != id) {
+			continue
+		}
+		if (target&0x80000000 != 0) != subdir {
+			return 0, ErrNotExist
+		}
+		return target &^ 0x80000000, nil
+	}
+	return 0, ErrNotExist
+}
+
+// GetMUIStringValueWith retrieves the localized string value for
+// the specified value name associated with an open key k, loading
+// the string from the files of an offline installation with m.
+// If the value name doesn't exist or the localized string value
+// can't be resolved, GetMUIStringValueWith returns ErrNotExist.
+func (k Key) GetMUIStringValueWith(name string, m *MUIResolver) (string, error) {
+	val, _, err := k.GetStringValue(name)
+	if err != nil {
+		return "", err
+	}
+	return m.Resolve(val)
+}
@@ -0,0 +1,148 @@
+package registry
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+)
+
+// testPEStrings returns a PE32+ image with a string table holding table
+func testPEStrings(table map[uint16]string) []byte {
+	const va = 0x1000
+	le := binary.LittleEndian
+
+	// group strings by block
+	blocks := map[uint32][]byte{}
+	for id := range table {
+		blk := uint32(id>>4) + 1
+		if blocks[blk] != nil {
+			continue
+		}
+		var b bytes.Buffer
+		for i := uint16(0); i < 16; i++ {
+			u := utf16.Encode([]rune(table[(id&^15)|i]))
+			binary.Write(&b, le, uint16(len(u)))
+			binary.Write(&b, le, u)
+		}
+		blocks[blk] = b.Bytes()
+	}
+
+	// root, type, and one name and language directory per block
+	n := uint32(len(blocks))
+	nameDir := uint32(16 + 8)
+	langDirs := nameDir + 16 + 8*n
+	entries := langDirs + (16+8)*n
+	data := entries + 16*n
+
+	rsrc := make([]byte, data)
+	le.PutUint16(rsrc[14:], 1)
+	le.PutUint32(rsrc[16:], rtString)
+	le.PutUint32(rsrc[20:], 0x80000000|nameDir)
+	le.PutUint16(rsrc[nameDir+14:], uint16(n))
+
+	i := uint32(0)
+	for blk, b := range blocks {
+		le.PutUint32(rsrc[nameDir+16+8*i:], blk)
+		lang := langDirs + 24*i
+		le.PutUint32(rsrc[nameDir+20+8*i:], 0x80000000|lang)
+		le.PutUint16(rsrc[lang+14:], 1)
+		le.PutUint32(rsrc[lang+16:], 0x409)
+		entry := entries + 16*i
+		le.PutUint32(rsrc[lang+20:], entry)
+		le.PutUint32(rsrc[entry:], va+uint32(len(rsrc)))
+		le.PutUint32(rsrc[entry+4:], uint32(len(b)))
+		rsrc = append(rsrc, b...)
+		i++
+	}
+	for len(rsrc)%0x200 != 0 {
+		rsrc = append(rsrc, 0)
+	}
+
+	var f bytes.Buffer
+	dos := make([]byte, 0x40)
+	copy(dos, "MZ")
+	le.PutUint32(dos[0x3c:], 0x40)
+	f.Write(dos)
+	f.WriteString("PE\x00\x00")
+	binary.Write(&f, le, pe.FileHeader{Machine: pe.IMAGE_FILE_MACHINE_AMD64, NumberOfSections: 1, SizeOfOptionalHeader: 240, Characteristics: 0x2022})
+	oh := pe.OptionalHeader64{Magic: 0x20b, SectionAlignment: 0x1000, FileAlignment: 0x200, SizeOfImage: va + uint32(len(rsrc)), SizeOfHeaders: 0x200, NumberOfRvaAndSizes: 16}
+	oh.DataDirectory[imageDirectoryEntryResource] = pe.DataDirectory{VirtualAddress: va, Size: uint32(len(rsrc))}
+	binary.Write(&f, le, oh)
+	sh := pe.SectionHeader32{VirtualSize: uint32(len(rsrc)), VirtualAddress: va, SizeOfRawData: uint32(len(rsrc)), PointerToRawData: 0x200, Characteristics: 0x40000040}
+	copy(sh.Name[:], ".rsrc")
+	binary.Write(&f, le, sh)
+	f.Write(make([]byte, 0x200-f.Len()))
+	f.Write(rsrc)
+	return f.Bytes()
+}
+
+func TestMUIResolver_Resolve(t *testing.T) {
+	root, err := ioutil.TempDir("", "mui")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	files := map[string][]byte{
+		"Windows/System32/test.dll":           testPEStrings(map[uint16]string{21787: "Neutral", 5: "Only in dll"}),
+		"Windows/System32/en-US/test.dll.mui": testPEStrings(map[uint16]string{21787: "English", 21786: "Other"}),
+		"Windows/System32/pt-PT/test.dll.mui": testPEStrings(map[uint16]string{21787: "Português"}),
+	}
+	for name, b := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		langs   []string
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{name: "not indirect", ref: "Plain", want: "Plain"},
+		{name: "default language", ref: `@%SystemRoot%\system32\TEST.DLL,-21787`, want: "English"},
+		{name: "language order", langs: []string{"pt-PT", "en-US"}, ref: `@%SystemRoot%\system32\test.dll,-21787`, want: "Português"},
+		{name: "same block", ref: `@%windir%\System32\test.dll,-21786;comment`, want: "Other"},
+		{name: "fallback to file", ref: `@C:\Windows\System32\test.dll,-5`, want: "Only in dll"},
+		{name: "file name only", ref: `@test.dll,-21787`, want: "English"},
+		{name: "missing id", ref: `@test.dll,-21700`, wantErr: true},
+		{name: "missing file", ref: `@missing.dll,-1`, wantErr: true},
+		{name: "package", ref: `@{Microsoft.Windows.Photos_8wekyb3d8bbwe?ms-resource://Microsoft.Windows.Photos/Files/Assets/PhotosAppList.png}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewMUIResolver(root, nil, tt.langs...).Resolve(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MUIResolver.Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("MUIResolver.Resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceStringBounds(t *testing.T) {
+	const va = 0x1000
+	image := testPEStrings(map[uint16]string{1: "one"})
+	rsrc := append([]byte{}, image[0x200:]...)
+	if got, err := resourceString(rsrc, va, 0, 1); err != nil || got != "one" {
+		t.Fatalf("resourceString() = %v, %v", got, err)
+	}
+
+	// the size of the string block of the only entry overflows the offset
+	const entry = 16 + 8 + 16 + 8 + 16 + 8
+	binary.LittleEndian.PutUint32(rsrc[entry+4:], 0xffffffff)
+	if _, err := resourceString(rsrc, va, 0, 1); err == nil || err.Error() != errBadResource.Error() {
+		t.Errorf("resourceString() error = %v, want %v", err, errBadResource)
+	}
+}
@@ -97,7 +97,7 @@ func (nk *namedKey) Read() error {
 	if err != nil {
 		return errorW{err: ErrCorruptRegistry, cause: err, function: "namedKey.Read() io.ReadFull"}
 	}
-	nk.name = string(buf)
+	nk.name = decodeName(buf, nk.flags&nk_KEY_COMP_NAME != 0)
 
 	loc, err := r.Seek(0, io.SeekCurrent)
 	if err != nil {
@@ -122,3 +122,53 @@ func (nk *namedKey) Read() error {
 
 	return nk.validate()
 }
+
+// isRoot returns true if nk is the root key of the hive
+func (nk *namedKey) isRoot() bool {
+	return nk.flags&nk_KEY_HIVE_ENTRY != 0
+}
+
+// Write writes the fixed size fields of nk. The name is not written
+func (nk *namedKey) Write() error {
+	r := nk.rws
+	_, err := r.Seek(nk.fpOffset, io.SeekStart)
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "namedKey.Write() r.Seek"}
+	}
+
+	// keep the fields that are not parsed
+	buf := make([]byte, 76)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "namedKey.Write() io.ReadFull"}
+	}
+
+	copy(buf[0:2], namedKeySig)
+	binary.LittleEndian.PutUint16(buf[2:4], nk.flags)
+	binary.LittleEndian.PutUint64(buf[4:12], nk.lastModified)
+	binary.LittleEndian.PutUint32(buf[16:20], nk.parentKeyOffset)
+	binary.LittleEndian.PutUint32(buf[20:24], nk.numberOfSubKeys)
+	binary.LittleEndian.PutUint32(buf[24:28], nk.numberOfVolatileSubKeys)
+	binary.LittleEndian.PutUint32(buf[28:32], nk.subKeysListOffset)
+	binary.LittleEndian.PutUint32(buf[32:36], nk.volatileListOffset)
+	binary.LittleEndian.PutUint32(buf[36:40], nk.numberOfValues)
+	binary.LittleEndian.PutUint32(buf[40:44], nk.valuesListOffset)
+	binary.LittleEndian.PutUint32(buf[44:48], nk.securityKeyOffset)
+	binary.LittleEndian.PutUint32(buf[48:52], nk.classNameOffset)
+	binary.LittleEndian.PutUint32(buf[52:56], nk.largestSubKeyNameSize)
+	binary.LittleEndian.PutUint32(buf[56:60], nk.largestSubKeyClassNameSize)
+	binary.LittleEndian.PutUint32(buf[60:64], nk.largestValueNameSize)
+	binary.LittleEndian.PutUint32(buf[64:68], nk.largestValueDataSize)
+	binary.LittleEndian.PutUint16(buf[72:74], nk.keyNameSize)
+	binary.LittleEndian.PutUint16(buf[74:76], nk.classNameSize)
+
+	_, err = r.Seek(nk.fpOffset, io.SeekStart)
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "namedKey.Write() r.Seek"}
+	}
+	_, err = r.Write(buf)
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "namedKey.Write() w.Write"}
+	}
+	return nil
+}
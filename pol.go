@@ -0,0 +1,273 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	polSignature = "PReg"
+	polVersion   = 1
+)
+
+// Registry.pol directives, found at the start of value names
+const (
+	polDelete       = "**del."         // deletes the value named after the prefix
+	polDeleteAll    = "**delvals."     // deletes all the values of the key
+	polDeleteValues = "**deletevalues" // deletes the values listed in the data
+	polDeleteKeys   = "**deletekeys"   // deletes the subkeys listed in the data
+	polSoft         = "**soft."        // sets the value named after the prefix if it does not exist
+	polDirective    = "**"
+)
+
+// PolEntry is a record of a Registry.pol file
+type PolEntry struct {
+	Key   string // path of the key, like Software\Policies\Microsoft\Windows
+	Value string // name of the value, or a directive like **del.Name
+	Type  uint32
+	Data  []byte
+}
+
+// ParsePol parses a Registry.pol file of Group Policy
+func ParsePol(rd io.Reader) ([]PolEntry, error) {
+	b, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 8 || string(b[:4]) != polSignature || binary.LittleEndian.Uint32(b[4:8]) != polVersion {
+		return nil, errorW{err: ErrBadRegistry, cause: errBadSignature, function: "ParsePol"}
+	}
+
+	p := polParser{b: b, pos: 8}
+	var entries []PolEntry
+	for p.pos < len(b) {
+		var e PolEntry
+		p.char('[')
+		e.Key = p.string()
+		p.char(';')
+		e.Value = p.string()
+		p.char(';')
+		e.Type = p.uint32()
+		p.char(';')
+		size := p.uint32()
+		p.char(';')
+		e.Data = p.bytes(int(size))
+		p.char(']')
+		if p.err != nil {
+			return nil, errorW{err: ErrBadRegistry, cause: p.err, function: "ParsePol"}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// polParser reads the fields of Registry.pol records, keeping the first error
+type polParser struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (p *polParser) bytes(n int) []byte {
+	if p.err != nil {
+		return nil
+	}
+	if n < 0 || p.pos+n > len(p.b) {
+		p.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := append([]byte{}, p.b[p.pos:p.pos+n]...)
+	p.pos += n
+	return b
+}
+
+func (p *polParser) uint16() uint16 {
+	b := p.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (p *polParser) uint32() uint32 {
+	b := p.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+// char reads the UTF-16 character c
+func (p *polParser) char(c uint16) {
+	if p.uint16() != c && p.err == nil {
+		p.err = errBadSignature
+	}
+}
+
+// string reads a UTF-16 string up to its NUL character
+func (p *polParser) string() string {
+	var u []uint16
+	for p.err == nil {
+		c := p.uint16()
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+// WritePol writes entries as a Registry.pol file
+func WritePol(w io.Writer, entries []PolEntry) error {
+	var buf bytes.Buffer
+	buf.WriteString(polSignature)
+	binary.Write(&buf, binary.LittleEndian, uint32(polVersion))
+
+	sep := utf16LEFromString(";")
+	for _, e := range entries {
+		buf.Write(utf16LEFromString("["))
+		buf.Write(append(utf16LEFromString(e.Key), 0, 0))
+		buf.Write(sep)
+		buf.Write(append(utf16LEFromString(e.Value), 0, 0))
+		buf.Write(sep)
+		binary.Write(&buf, binary.LittleEndian, e.Type)
+		buf.Write(sep)
+		binary.Write(&buf, binary.LittleEndian, uint32(len(e.Data)))
+		buf.Write(sep)
+		buf.Write(e.Data)
+		buf.Write(utf16LEFromString("]"))
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ApplyPol applies entries, in order, to key k and its subkeys, as Group
+// Policy does. root is the path of k in the entries, like Software for
+// the root key of a SOFTWARE hive; entries outside of root are ignored.
+// Directives deleting missing keys or values are ignored, the
+// **SecureKey and other unknown directives only create their key.
+// If ApplyPol fails, the registry is left unchanged.
+func (k Key) ApplyPol(entries []PolEntry, root string) error {
+	r := k.registry
+	if r.cells == nil {
+		return ErrReadOnly
+	}
+	root = strings.Trim(root, string(separator))
+
+	err := r.atomic(func() error {
+		for _, e := range entries {
+			path, ok := trimRegRoot(strings.Trim(e.Key, string(separator)), root)
+			if !ok {
+				continue
+			}
+			err := k.applyPolEntry(path, e)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		r.root.Read()
+		k.nk.Read()
+		return err
+	}
+	return k.nk.Read()
+}
+
+// applyPolEntry applies entry e to the subkey path of k
+func (k Key) applyPolEntry(path string, e PolEntry) error {
+	name := strings.ToLower(e.Value)
+
+	// directives deleting keys and values
+	var deleted []string
+	switch {
+	case strings.HasPrefix(name, polDelete):
+		deleted = []string{e.Value[len(polDelete):]}
+	case name == polDeleteAll, name == polDeleteValues:
+		key, err := k.subKey(path, false)
+		if err == ErrNotExist {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if name == polDeleteAll {
+			key = newKey(key.registry, key.rws, key.nk) // a cursor of its own
+			deleted, err = key.ReadValueNames(-1)
+			if err != nil {
+				return err
+			}
+			for i, v := range deleted {
+				if v == defaultValueName {
+					deleted[i] = ""
+				}
+			}
+		} else {
+			deleted = strings.Split(stringFromBytes(e.Data), ";")
+		}
+	case name == polDeleteKeys:
+		for _, sub := range strings.Split(stringFromBytes(e.Data), ";") {
+			if sub == "" {
+				continue
+			}
+			err := k.deleteTree(joinPath(path, sub))
+			if err != nil && err != ErrNotExist {
+				return err
+			}
+		}
+		return nil
+	}
+	if deleted != nil {
+		key, err := k.subKey(path, false)
+		if err == ErrNotExist {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, v := range deleted {
+			if v == "" && name != polDeleteAll {
+				continue
+			}
+			err = key.DeleteValue(v)
+			if err != nil && err != ErrNotExist {
+				return err
+			}
+		}
+		return nil
+	}
+
+	key, err := k.subKey(path, true)
+	if err != nil {
+		return err
+	}
+	switch {
+	case strings.HasPrefix(name, polSoft):
+		_, err = key.getValue(e.Value[len(polSoft):])
+		if err != ErrNotExist {
+			return err
+		}
+		return key.setValue(e.Value[len(polSoft):], e.Type, e.Data)
+	case strings.HasPrefix(name, polDirective), e.Value == "" && len(e.Data) == 0:
+		return nil
+	}
+	return key.setValue(e.Value, e.Type, e.Data)
+}
+
+// subKey opens the subkey path of k, k itself if path is empty,
+// creating it if create is true
+func (k Key) subKey(path string, create bool) (Key, error) {
+	if path == "" {
+		return k, nil
+	}
+	if create {
+		sub, _, err := k.CreateKey(path)
+		return sub, err
+	}
+	return k.OpenSubKey(path)
+}
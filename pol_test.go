@@ -0,0 +1,135 @@
+package registry
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParsePol(t *testing.T) {
+	entries := []PolEntry{
+		{Key: `Software\Policies\Test`, Value: "String", Type: REG_SZ, Data: append(utf16LEFromString("é"), 0, 0)},
+		{Key: `Software\Policies\Test`, Value: "**del.Old", Type: REG_SZ, Data: []byte{' ', 0, 0, 0}},
+		{Key: `Software\Policies\Empty`, Value: "", Type: REG_NONE, Data: []byte{}},
+	}
+	var buf bytes.Buffer
+	if err := WritePol(&buf, entries); err != nil {
+		t.Fatalf("WritePol() error = %v", err)
+	}
+	want := "PReg\x01\x00\x00\x00[\x00S\x00o\x00f\x00t\x00w\x00a\x00r\x00e\x00\\\x00P\x00o\x00l\x00i\x00c\x00i\x00e\x00s\x00\\\x00" +
+		"T\x00e\x00s\x00t\x00\x00\x00;\x00S\x00t\x00r\x00i\x00n\x00g\x00\x00\x00;\x00\x01\x00\x00\x00;\x00\x04\x00\x00\x00;\x00\xe9\x00\x00\x00]\x00"
+	if got := buf.String(); got[:len(want)] != want {
+		t.Errorf("WritePol() = %q, want %q", got[:len(want)], want)
+	}
+
+	got, err := ParsePol(&buf)
+	if err != nil {
+		t.Fatalf("ParsePol() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("ParsePol() = %+v, want %+v", got, entries)
+	}
+
+	for _, b := range []string{"", "PReg\x02\x00\x00\x00", want[:len(want)-2], want[:len(want)-2] + "}\x00"} {
+		if _, err := ParsePol(bytes.NewReader([]byte(b))); err == nil {
+			t.Errorf("ParsePol(%q) error = nil", b)
+		}
+	}
+}
+
+func TestKey_ApplyPol(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	root, err := r.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, _, err := root.CreateKey(`Software\Policies\Test`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, set := range []error{
+		k.SetStringValue("", "default"),
+		k.SetStringValue("a", "a"),
+		k.SetStringValue("b", "b"),
+		k.SetStringValue("Soft", "kept"),
+	} {
+		if set != nil {
+			t.Fatal(set)
+		}
+	}
+	if _, _, err := k.CreateKey(`Sub\Sub`); err != nil {
+		t.Fatal(err)
+	}
+
+	dword := []byte{1, 0, 0, 0}
+	entries := []PolEntry{
+		{Key: `Software\Policies\Test`, Value: "Kept", Type: REG_DWORD, Data: dword},
+		{Key: `Software\Policies\Test`, Value: "Deleted", Type: REG_DWORD, Data: dword},
+		{Key: `Software\Policies\Test`, Value: "Listed", Type: REG_DWORD, Data: dword},
+		{Key: `Software\Policies\Test`, Value: "**del.Deleted", Type: REG_SZ, Data: []byte{' ', 0, 0, 0}},
+		{Key: `Software\Policies\Test`, Value: "**DeleteValues", Type: REG_SZ, Data: append(utf16LEFromString("Listed;Missing;"), 0, 0)},
+		{Key: `Software\Policies\Test`, Value: "**DeleteKeys", Type: REG_SZ, Data: append(utf16LEFromString("Sub;Missing"), 0, 0)},
+		{Key: `Software\Policies\Test`, Value: "**soft.Soft", Type: REG_DWORD, Data: dword},
+		{Key: `Software\Policies\Test`, Value: "**soft.New", Type: REG_DWORD, Data: dword},
+		{Key: `Software\Policies\Missing`, Value: "**del.Missing", Type: REG_SZ, Data: []byte{' ', 0, 0, 0}},
+		{Key: `Software\Policies\Empty`, Value: "", Type: REG_NONE},
+		{Key: `Software\Policies\Secure`, Value: "**SecureKey", Type: REG_DWORD, Data: dword},
+		{Key: `Other\Key`, Value: "Ignored", Type: REG_DWORD, Data: dword},
+	}
+	software, err := root.OpenSubKey("Software")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := software.ApplyPol(entries, "Software"); err != nil {
+		t.Fatalf("Key.ApplyPol() error = %v", err)
+	}
+
+	k, err = r.OpenKey(`Software\Policies\Test`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := k.ReadValueNames(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"(default)", "Kept", "New", "Soft", "a", "b"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("values = %v, want %v", names, want)
+	}
+	if s, _, err := k.GetStringValue("Soft"); err != nil || s != "kept" {
+		t.Errorf("Soft = %v, %v", s, err)
+	}
+	if n := k.nk.numberOfSubKeys; n != 0 {
+		t.Errorf("Test has %v subkeys, want 0", n)
+	}
+
+	entries = append(entries, PolEntry{Key: `Software\Policies\Test`, Value: "**delvals.", Type: REG_SZ, Data: []byte{' ', 0, 0, 0}})
+	if err := software.ApplyPol(entries[len(entries)-1:], "Software"); err != nil {
+		t.Fatalf("Key.ApplyPol() error = %v", err)
+	}
+	k, err = r.OpenKey(`Software\Policies\Test`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := k.nk.numberOfValues; n != 0 {
+		t.Errorf("Test has %v values after **delvals., want 0", n)
+	}
+	for path, want := range map[string]error{
+		`Software\Policies\Missing`: ErrNotExist,
+		`Software\Policies\Empty`:   nil,
+		`Software\Policies\Secure`:  nil,
+		`Software\Other`:            ErrNotExist,
+		`Other`:                     ErrNotExist,
+	} {
+		if _, err := r.OpenKey(path); err != want {
+			t.Errorf("OpenKey(%v) error = %v, want %v", path, err, want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// .reg file versions
+const (
+	RegVersion5 = 5 // "Windows Registry Editor Version 5.00", UTF-16LE
+	RegVersion4 = 4 // "REGEDIT4", ANSI
+)
+
+const (
+	regHeader5 = "Windows Registry Editor Version 5.00"
+	regHeader4 = "REGEDIT4"
+
+	// maxHexChars is the column after which regedit wraps hex data
+	maxHexChars = 77
+)
+
+// RegOptions are the options of Key.ExportReg
+type RegOptions struct {
+	// Version is RegVersion5, the default, or RegVersion4
+	Version int
+
+	// Root is the path written before the path of the keys,
+	// like HKEY_LOCAL_MACHINE\SOFTWARE. If empty, the name of the
+	// root key of the registry is used.
+	Root string
+}
+
+// ExportReg writes key k, its values and subkeys to w in the .reg
+// format of regedit. Version 5 files are written in UTF-16LE with
+// a byte order mark, version 4 files in ANSI (Latin-1).
+func (k Key) ExportReg(w io.Writer, opts *RegOptions) error {
+	if opts == nil {
+		opts = &RegOptions{}
+	}
+	rw := &regWriter{w: w, unicode: opts.Version != RegVersion4}
+
+	root := strings.TrimRight(opts.Root, string(separator))
+	if root == "" {
+		root = k.registry.root.name
+	}
+	path, err := k.Path()
+	if err != nil {
+		return err
+	}
+	if path != "" {
+		root += string(separator) + path
+	}
+
+	if rw.unicode {
+		rw.writeRaw([]byte{0xff, 0xfe})
+		rw.writeLine(regHeader5)
+	} else {
+		rw.writeLine(regHeader4)
+	}
+	rw.writeLine("")
+	err = rw.exportKey(k, root)
+	if err != nil {
+		return err
+	}
+	return rw.err
+}
+
+// regWriter writes .reg files, keeping the first error
+type regWriter struct {
+	w       io.Writer
+	unicode bool
+	err     error
+}
+
+func (rw *regWriter) writeRaw(b []byte) {
+	if rw.err == nil {
+		_, rw.err = rw.w.Write(b)
+	}
+}
+
+// writeString writes s in the encoding of the file
+func (rw *regWriter) writeString(s string) {
+	if rw.unicode {
+		rw.writeRaw(utf16LEFromString(s))
+		return
+	}
+	rw.writeRaw(latin1FromString(s))
+}
+
+func (rw *regWriter) writeLine(s string) {
+	rw.writeString(s + "\r\n")
+}
+
+// exportKey writes key k, known as path, and its subkeys
+func (rw *regWriter) exportKey(k Key, path string) error {
+	k = newKey(k.registry, k.rws, k.nk) // keep the cursor of k
+	rw.writeLine("[" + path + "]")
+
+	values, err := k.ReadValues(-1)
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		rw.exportValue(v)
+	}
+	rw.writeLine("")
+
+	names, err := k.ReadSubKeyNames(-1)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(names, func(i, j int) bool { return compareKeyNames(names[i], names[j]) < 0 })
+	for _, name := range names {
+		sub, err := k.OpenSubKey(name)
+		if err != nil {
+			return err
+		}
+		err = rw.exportKey(sub, path+string(separator)+name)
+		if err != nil {
+			return err
+		}
+	}
+	return rw.err
+}
+
+// exportValue writes the line of value v
+func (rw *regWriter) exportValue(v Value) {
+	rw.writeLine(regValueLine(v, rw.unicode))
+}
+
+// regValueLine returns the line of value v, without the line break.
+// unicode is false for version 4 files.
+func regValueLine(v Value, unicode bool) string {
+	name := "@"
+	if v.Name != defaultValueName {
+		name = `"` + escapeRegString(v.Name) + `"`
+	}
+	name += "="
+
+	switch v.Type {
+	case REG_SZ:
+		if s, ok := regString(v.raw); ok {
+			return name + `"` + escapeRegString(s) + `"`
+		}
+	case REG_DWORD:
+		if len(v.raw) == 4 {
+			return name + fmt.Sprintf("dword:%08x", binary.LittleEndian.Uint32(v.raw))
+		}
+	}
+
+	data := v.raw
+	if !unicode && (v.Type == REG_EXPAND_SZ || v.Type == REG_MULTI_SZ) {
+		data = latin1FromUTF16LE(data)
+	}
+	return name + hexRegData(len(name), v.Type, data)
+}
+
+// hexRegData formats data as "hex:" or "hex(type):" followed by the bytes,
+// wrapping the lines as regedit does. column is the length of the line
+// before the data.
+func hexRegData(column int, valtype uint32, data []byte) string {
+	var sb strings.Builder
+	if valtype == REG_BINARY {
+		sb.WriteString("hex:")
+	} else {
+		fmt.Fprintf(&sb, "hex(%x):", valtype)
+	}
+	column += sb.Len()
+
+	for i, b := range data {
+		fmt.Fprintf(&sb, "%02x", b)
+		if i == len(data)-1 {
+			break
+		}
+		sb.WriteByte(',')
+		column += 3
+		if column >= maxHexChars {
+			sb.WriteString("\\\r\n  ")
+			column = 2
+		}
+	}
+	return sb.String()
+}
+
+// regString returns the string of REG_SZ data b if it can be written
+// as a quoted string: UTF-16 terminated by a single NUL character,
+// without line breaks that regedit can not escape
+func regString(b []byte) (string, bool) {
+	if len(b)%2 != 0 {
+		return "", false
+	}
+	if len(b) == 0 {
+		return "", true
+	}
+	s := stringFromBytes(b)
+	return s, !strings.ContainsAny(s, "\x00\r\n") && bytes.Equal(append(utf16LEFromString(s), 0, 0), b)
+}
+
+// escapeRegString escapes the backslashes and quotes of s, the only
+// escape sequences of regedit
+func escapeRegString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+// latin1FromString encodes s in Latin-1, replacing other characters by '?'
+func latin1FromString(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff {
+			r = '?'
+		}
+		b = append(b, byte(r))
+	}
+	return b
+}
+
+// latin1FromUTF16LE converts UTF-16LE data to Latin-1, keeping NUL characters
+func latin1FromUTF16LE(b []byte) []byte {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return latin1FromString(string(utf16.Decode(u)))
+}
@@ -0,0 +1,115 @@
+package registry
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestKey_ExportReg(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	root, err := r.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, _, err := root.CreateKey(`Export\Sub`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := root.CreateKey(`Export\a`); err != nil {
+		t.Fatal(err)
+	}
+	k, _ = k.Parent()
+	for _, set := range []error{
+		k.SetStringValue("", "default"),
+		k.SetStringValue(`Quote"Back\slash`, `C:\`),
+		k.SetStringValue("Lines", "a\r\nb"),
+		k.SetStringValue("Ünicode", "€"),
+		k.SetDWordValue("DWord", 0x12ab),
+		k.SetQWordValue("QWord", 0x0102030405060708),
+		k.SetBinaryValue("Binary", bytes.Repeat([]byte{0xab}, 30)),
+		k.SetBinaryValue("Empty", nil),
+		k.SetStringsValue("Multi", []string{"a", "b"}),
+		k.SetExpandStringValue("Expand", "%x%"),
+		k.setValue("None", REG_NONE, []byte{1}),
+		k.setValue("Custom", 0x20, []byte{1, 2}),
+		k.setValue("BadString", REG_SZ, []byte{'a', 0, 0, 0, 'b', 0, 0, 0}),
+	} {
+		if set != nil {
+			t.Fatal(set)
+		}
+	}
+
+	values := "" +
+		"@=\"default\"\r\n" +
+		"\"Quote\\\"Back\\\\slash\"=\"C:\\\\\"\r\n" +
+		"\"Lines\"=hex(1):61,00,0d,00,0a,00,62,00,00,00\r\n" +
+		"\"Ünicode\"=\"€\"\r\n" +
+		"\"DWord\"=dword:000012ab\r\n" +
+		"\"QWord\"=hex(b):08,07,06,05,04,03,02,01\r\n" +
+		"\"Binary\"=hex:ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,\\\r\n" +
+		"  ab,ab,ab,ab,ab,ab,ab,ab\r\n" +
+		"\"Empty\"=hex:\r\n" +
+		"\"Multi\"=hex(7):61,00,00,00,62,00,00,00,00,00\r\n" +
+		"\"Expand\"=hex(2):25,00,78,00,25,00,00,00\r\n" +
+		"\"None\"=hex(0):01\r\n" +
+		"\"Custom\"=hex(20):01,02\r\n" +
+		"\"BadString\"=hex(1):61,00,00,00,62,00,00,00\r\n" +
+		"\r\n"
+	want5 := "Windows Registry Editor Version 5.00\r\n\r\n" +
+		"[HKEY_CURRENT_USER\\Export]\r\n" + values +
+		"[HKEY_CURRENT_USER\\Export\\a]\r\n\r\n" +
+		"[HKEY_CURRENT_USER\\Export\\Sub]\r\n\r\n"
+
+	var buf bytes.Buffer
+	if err := k.ExportReg(&buf, &RegOptions{Root: `HKEY_CURRENT_USER\`}); err != nil {
+		t.Fatalf("Key.ExportReg() error = %v", err)
+	}
+	want := append([]byte{0xff, 0xfe}, utf16LEFromString(want5)...)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Key.ExportReg() = %q, want %q", stringFromBytes(buf.Bytes()[2:]), want5)
+	}
+
+	// version 4 files are ANSI, with ANSI string data
+	values = strings.Replace(values, "hex(7):61,00,00,00,62,00,00,00,00,00", "hex(7):61,00,62,00,00", 1)
+	values = strings.Replace(values, "hex(2):25,00,78,00,25,00,00,00", "hex(2):25,78,25,00", 1)
+	values = strings.Replace(values, "€", "?", 1)
+	want4 := "REGEDIT4\r\n\r\n" +
+		"[ROOT\\Export]\r\n" + values +
+		"[ROOT\\Export\\a]\r\n\r\n" +
+		"[ROOT\\Export\\Sub]\r\n\r\n"
+	buf.Reset()
+	if err := k.ExportReg(&buf, &RegOptions{Version: RegVersion4}); err != nil {
+		t.Fatalf("Key.ExportReg() error = %v", err)
+	}
+	if got := buf.String(); got != string(latin1FromString(want4)) {
+		t.Errorf("Key.ExportReg() = %q, want %q", got, want4)
+	}
+}
+
+func TestHexRegData(t *testing.T) {
+	tests := []struct {
+		column  int
+		valtype uint32
+		data    []byte
+		want    string
+	}{
+		{7, REG_EXPAND_SZ, make([]byte, 22), "hex(2):" + strings.Repeat("00,", 21) + "\\\r\n  00"},
+		{6, REG_EXPAND_SZ, make([]byte, 23), "hex(2):" + strings.Repeat("00,", 22) + "\\\r\n  00"},
+		{2, REG_BINARY, make([]byte, 50), "hex:" + strings.Repeat("00,", 24) + "\\\r\n  " + strings.Repeat("00,", 25) + "\\\r\n  00"},
+		{2, REG_BINARY, nil, "hex:"},
+	}
+	for _, tt := range tests {
+		if got := hexRegData(tt.column, tt.valtype, tt.data); got != tt.want {
+			t.Errorf("hexRegData(%v, %v, %v) = %q, want %q", tt.column, tt.valtype, len(tt.data), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// RegFile is the content of a .reg file
+type RegFile struct {
+	Version int // RegVersion5 or RegVersion4
+	Keys    []RegKey
+}
+
+// RegKey is a key section of a .reg file
+type RegKey struct {
+	Path   string // full path, like HKEY_LOCAL_MACHINE\SOFTWARE\Vendor
+	Delete bool   // [-path] removes the key and its subkeys
+	Values []RegValue
+}
+
+// RegValue is a value line of a .reg file
+type RegValue struct {
+	// Name is empty for the default value (@), which Value and ValueDiff
+	// name "(default)". The Get and Set methods of Key accept both.
+	Name   string
+	Delete bool // "name"=- removes the value
+	Type   uint32
+	Data   []byte // data as stored in the registry
+}
+
+// ParseReg parses a .reg file in REGEDIT4 (ANSI) or version 5.00
+// (UTF-16LE with a byte order mark) format. UTF-8 files with a byte
+// order mark are accepted too.
+func ParseReg(rd io.Reader) (*RegFile, error) {
+	b, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(decodeRegText(b), "\n")
+
+	f := &RegFile{}
+	p := regParser{file: f}
+	for i := 0; i < len(lines); i++ {
+		p.line = i + 1
+		line := strings.TrimSpace(strings.TrimSuffix(lines[i], "\r"))
+
+		// hex data continues on the next lines
+		for strings.HasSuffix(line, `\`) && isHexLine(line) && i+1 < len(lines) {
+			i++
+			next := strings.TrimSpace(strings.TrimSuffix(lines[i], "\r"))
+			if strings.HasPrefix(next, ";") {
+				continue
+			}
+			line = strings.TrimSuffix(line, `\`) + next
+		}
+
+		err = p.parseLine(line)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if f.Version == 0 {
+		return nil, errorW{err: ErrBadRegistry, cause: errRegHeader, function: "ParseReg"}
+	}
+	return f, nil
+}
+
+// decodeRegText decodes the text of a .reg file
+func decodeRegText(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xff, 0xfe}):
+		return string(utf16.Decode(utf16FromBytes(b[2:])))
+	case bytes.HasPrefix(b, []byte{0xef, 0xbb, 0xbf}):
+		return string(b[3:])
+	}
+	return latin1ToString(b)
+}
+
+func latin1ToString(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+type regParser struct {
+	file *RegFile
+	line int
+}
+
+func (p *regParser) errorf(format string, a ...interface{}) error {
+	return errorW{
+		err:      ErrBadRegistry,
+		cause:    fmt.Errorf("line %d: "+format, append([]interface{}{p.line}, a...)...),
+		function: "ParseReg",
+	}
+}
+
+// isHexLine reports whether line is a value line with hex data
+func isHexLine(line string) bool {
+	_, rest, err := parseRegName(line)
+	return err == nil && strings.HasPrefix(strings.TrimSpace(rest), "hex")
+}
+
+func (p *regParser) parseLine(line string) error {
+	f := p.file
+	switch {
+	case line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#"):
+		return nil
+
+	case f.Version == 0:
+		switch line {
+		case regHeader5:
+			f.Version = RegVersion5
+		case regHeader4:
+			f.Version = RegVersion4
+		default:
+			return errorW{err: ErrBadRegistry, cause: errRegHeader, function: "ParseReg"}
+		}
+		return nil
+
+	case strings.HasPrefix(line, "["):
+		if !strings.HasSuffix(line, "]") {
+			return p.errorf("unterminated key %q", line)
+		}
+		key := RegKey{Path: line[1 : len(line)-1]}
+		if strings.HasPrefix(key.Path, "-") {
+			key.Delete = true
+			key.Path = key.Path[1:]
+		}
+		key.Path = strings.Trim(key.Path, string(separator))
+		if key.Path == "" {
+			return p.errorf("empty key path")
+		}
+		f.Keys = append(f.Keys, key)
+		return nil
+	}
+
+	if len(f.Keys) == 0 {
+		return p.errorf("value outside of a key")
+	}
+	v, err := p.parseValue(line)
+	if err != nil {
+		return err
+	}
+	key := &f.Keys[len(f.Keys)-1]
+	key.Values = append(key.Values, v)
+	return nil
+}
+
+// parseValue parses a "name"=data line
+func (p *regParser) parseValue(line string) (RegValue, error) {
+	name, data, err := parseRegName(line)
+	if err != nil {
+		return RegValue{}, p.errorf("%v", err)
+	}
+	v := RegValue{Name: name}
+
+	data = strings.TrimSpace(data)
+	switch {
+	case data == "-":
+		v.Delete = true
+
+	case strings.HasPrefix(data, `"`):
+		s, rest, err := unquoteRegString(data)
+		if err != nil || strings.TrimSpace(rest) != "" {
+			return v, p.errorf("invalid string %s", data)
+		}
+		v.Type = REG_SZ
+		v.Data = append(utf16LEFromString(s), 0, 0)
+
+	case strings.HasPrefix(data, "dword:"):
+		n, err := strconv.ParseUint(strings.TrimSpace(data[len("dword:"):]), 16, 32)
+		if err != nil {
+			return v, p.errorf("invalid dword %s", data)
+		}
+		v.Type = REG_DWORD
+		v.Data = make([]byte, 4)
+		binary.LittleEndian.PutUint32(v.Data, uint32(n))
+
+	case strings.HasPrefix(data, "hex"):
+		v.Type = REG_BINARY
+		data = data[len("hex"):]
+		if strings.HasPrefix(data, "(") {
+			end := strings.IndexByte(data, ')')
+			if end < 0 {
+				return v, p.errorf("invalid hex type %s", data)
+			}
+			n, err := strconv.ParseUint(data[1:end], 16, 32)
+			if err != nil {
+				return v, p.errorf("invalid hex type %s", data)
+			}
+			v.Type = uint32(n)
+			data = data[end+1:]
+		}
+		if !strings.HasPrefix(data, ":") {
+			return v, p.errorf("invalid hex data %s", data)
+		}
+		v.Data, err = parseRegHex(data[1:])
+		if err != nil {
+			return v, p.errorf("invalid hex data %s", data)
+		}
+
+		// REGEDIT4 strings are ANSI
+		if p.file.Version == RegVersion4 && (v.Type == REG_EXPAND_SZ || v.Type == REG_MULTI_SZ) {
+			v.Data = utf16LEFromString(latin1ToString(v.Data))
+		}
+
+	default:
+		return v, p.errorf("invalid value data %s", data)
+	}
+	return v, nil
+}
+
+// parseRegName parses the name of a value line and returns the data after '='
+func parseRegName(line string) (name, data string, err error) {
+	if strings.HasPrefix(line, "@") {
+		data = strings.TrimSpace(line[1:])
+	} else {
+		name, data, err = unquoteRegString(line)
+		if err != nil {
+			return "", "", err
+		}
+		if name == "" {
+			return "", "", fmt.Errorf("empty value name")
+		}
+		data = strings.TrimSpace(data)
+	}
+	if !strings.HasPrefix(data, "=") {
+		return "", "", fmt.Errorf("missing '=' in %q", line)
+	}
+	return name, data[1:], nil
+}
+
+// unquoteRegString parses the quoted string at the start of s,
+// returning it unescaped and the rest of s. Like regedit, only \\ and
+// \" are escape sequences, other backslashes are kept.
+func unquoteRegString(s string) (str, rest string, err error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", "", fmt.Errorf("missing quote in %q", s)
+	}
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return sb.String(), s[i+1:], nil
+		case '\\':
+			i++
+			if i == len(s) {
+				break
+			}
+			if s[i] != '\\' && s[i] != '"' {
+				sb.WriteByte('\\')
+			}
+			sb.WriteByte(s[i])
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string %q", s)
+}
+
+// parseRegHex parses comma separated bytes
+func parseRegHex(s string) ([]byte, error) {
+	s = strings.Replace(s, " ", "", -1)
+	s = strings.Replace(s, "\t", "", -1)
+	s = strings.TrimSuffix(s, ",")
+	if s == "" {
+		return []byte{}, nil
+	}
+
+	fields := strings.Split(s, ",")
+	b := make([]byte, len(fields))
+	for i, f := range fields {
+		if len(f) == 0 || len(f) > 2 {
+			return nil, hex.ErrLength
+		}
+		n, err := strconv.ParseUint(f, 16, 8)
+		if err != nil {
+			return nil, err
+		}
+		b[i] = byte(n)
+	}
+	return b, nil
+}
+
+// ImportReg applies the keys and values of f to registry r. root is the
+// path of the root key of r in f, like HKEY_LOCAL_MACHINE\SOFTWARE; keys
+// outside of root are ignored. Keys are created as needed and deleted
+// with their subkeys. If the import fails, the registry is left unchanged.
+func (r Registry) ImportReg(f *RegFile, root string) error {
+	keys := make([]importRecord, len(f.Keys))
+	for i, key := range f.Keys {
+		keys[i] = importRecord{RegKey: key}
+	}
+	return r.importKeys(keys, root)
+}
+
+// importRecord is a key to import, with the data of the formats
+// keeping more than .reg files
+type importRecord struct {
+	RegKey
+	class        string
+	lastModified time.Time
+	flags        *uint16
+	create       bool // existing values are left unchanged
+}
+
+// importKeys applies keys to registry r, see ImportReg
+func (r Registry) importKeys(keys []importRecord, root string) error {
+	if r.cells == nil {
+		return ErrReadOnly
+	}
+	root = strings.Trim(root, string(separator))
+
+	err := r.atomic(func() error {
+		for _, key := range keys {
+			path, ok := trimRegRoot(key.Path, root)
+			if !ok {
+				continue
+			}
+			err := r.importKey(key, path)
+			if err != nil {
+				return err
+			}
+		}
+
+		// last write times and flags are set once all the subkeys are created
+		for _, key := range keys {
+			path, ok := trimRegRoot(key.Path, root)
+			if !ok || key.Delete || (key.lastModified.IsZero() && key.flags == nil) {
+				continue
+			}
+			k, err := r.OpenKey(path)
+			if err != nil {
+				return err
+			}
+			if !key.lastModified.IsZero() {
+				k.nk.lastModified = filetime(key.lastModified)
+			}
+			if key.flags != nil {
+				// the flags describing how the key is stored are kept
+				const stored = nk_KEY_COMP_NAME | nk_KEY_HIVE_ENTRY | nk_KEY_HIVE_EXIT | nk_KEY_IS_VOLATILE
+				k.nk.flags = k.nk.flags&stored | *key.flags&^stored
+			}
+			err = k.nk.Write()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		r.root.Read()
+		return err
+	}
+	return r.root.Read()
+}
+
+// trimRegRoot returns path relative to root, compared case insensitively
+func trimRegRoot(path, root string) (string, bool) {
+	if root == "" {
+		return path, true
+	}
+	if strings.EqualFold(path, root) {
+		return "", true
+	}
+	if len(path) > len(root) && strings.EqualFold(path[:len(root)], root) && path[len(root)] == separator {
+		return path[len(root)+1:], true
+	}
+	return "", false
+}
+
+// importKey applies key, whose path relative to the root key is path
+func (r Registry) importKey(key importRecord, path string) error {
+	root, err := r.OpenKey("")
+	if err != nil {
+		return err
+	}
+
+	if key.Delete {
+		if path == "" {
+			return ErrNoDelete
+		}
+		err = root.deleteTree(path)
+		if err == ErrNotExist {
+			return nil
+		}
+		return err
+	}
+
+	k := root
+	if path != "" {
+		k, _, err = root.CreateKey(path)
+		if err != nil {
+			return err
+		}
+	}
+	if key.class != "" {
+		err = k.setClassName(key.class)
+		if err != nil {
+			return err
+		}
+	}
+	for _, v := range key.Values {
+		switch {
+		case v.Delete:
+			err = k.DeleteValue(v.Name)
+			if err == ErrNotExist {
+				err = nil
+			}
+		case key.create:
+			_, err = k.getValue(v.Name)
+			if err == ErrNotExist {
+				err = k.setValue(v.Name, v.Type, v.Data)
+			}
+		default:
+			err = k.setValue(v.Name, v.Type, v.Data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
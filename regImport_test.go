@@ -0,0 +1,138 @@
+package registry
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRegistry_ImportReg(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	src, err := Open("testdata/NTUSER.DAT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	root, err := src.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// export and import back the whole registry, in both formats
+	for _, version := range []int{RegVersion5, RegVersion4} {
+		var buf bytes.Buffer
+		if err := root.ExportReg(&buf, &RegOptions{Version: version, Root: `HKEY_CURRENT_USER`}); err != nil {
+			t.Fatal(err)
+		}
+		f, err := ParseReg(&buf)
+		if err != nil {
+			t.Fatalf("ParseReg() error = %v", err)
+		}
+		if f.Version != version {
+			t.Errorf("ParseReg() version = %v, want %v", f.Version, version)
+		}
+
+		d, err := Create(filepath.Join(filepath.Dir(file), "import.dat"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.ImportReg(f, `hkey_current_user\`); err != nil {
+			t.Fatalf("Registry.ImportReg() error = %v", err)
+		}
+		if version == RegVersion4 {
+			// the test registry has no characters outside of Latin-1
+			d.Close()
+			continue
+		}
+		if got, want := testTree(t, d), testTree(t, src); !reflect.DeepEqual(got, want) {
+			t.Errorf("imported registry has %v keys, want %v", len(got), len(want))
+			for path, values := range want {
+				if !reflect.DeepEqual(got[path], values) {
+					t.Errorf("values of %v = %v, want %v", path, got[path], values)
+				}
+			}
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	reg := "REGEDIT4\r\n" +
+		"; policy\r\n" +
+		"[HKEY_CURRENT_USER\\Control Panel\\Desktop]\r\n" +
+		"\"Wallpaper\"=-\r\n" +
+		"\"Missing\"=-\r\n" +
+		"\"Path\"=hex(2):25,77,69,\\\r\n" +
+		"  6e,64,69,72,25,\\\r\n" +
+		"; comment\r\n" +
+		"  e9,00\r\n" +
+		"@=\"C:\\\\\\\"x\\\"\"\r\n" +
+		"\"Unescaped\"=\"C:\\new\\0\"\r\n" +
+		"\r\n" +
+		"[-HKEY_CURRENT_USER\\SOFTWARE\\Microsoft]\r\n" +
+		"[-HKEY_CURRENT_USER\\Missing]\r\n" +
+		"[HKEY_LOCAL_MACHINE\\Other]\r\n" +
+		"\"Ignored\"=dword:1\r\n"
+	f, err := ParseReg(bytes.NewReader(latin1FromString(reg)))
+	if err != nil {
+		t.Fatalf("ParseReg() error = %v", err)
+	}
+	if err := r.ImportReg(f, "HKEY_CURRENT_USER"); err != nil {
+		t.Fatalf("Registry.ImportReg() error = %v", err)
+	}
+
+	k, err := r.OpenKey(`Control Panel\Desktop`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := k.GetStringValue("Wallpaper"); err != ErrNotExist {
+		t.Errorf("deleted value error = %v, want %v", err, ErrNotExist)
+	}
+	if s, _, err := k.GetStringValue("Path"); err != nil || s != "%windir%é" {
+		t.Errorf("Path = %q, %v", s, err)
+	}
+	if s, _, err := k.GetStringValue(""); err != nil || s != `C:\"x"` {
+		t.Errorf("default value = %q, %v", s, err)
+	}
+	if s, _, err := k.GetStringValue("Unescaped"); err != nil || s != `C:\new\0` {
+		t.Errorf("Unescaped = %q, %v", s, err)
+	}
+	if _, err := r.OpenKey(`SOFTWARE\Microsoft`); err != ErrNotExist {
+		t.Errorf("deleted key error = %v, want %v", err, ErrNotExist)
+	}
+	if _, err := r.OpenKey(`SOFTWARE`); err != nil {
+		t.Errorf("parent of deleted key error = %v", err)
+	}
+	if _, err := r.OpenKey(`Other`); err != ErrNotExist {
+		t.Errorf("key outside of root error = %v, want %v", err, ErrNotExist)
+	}
+}
+
+func TestParseReg_errors(t *testing.T) {
+	tests := []string{
+		"",
+		"REGEDIT5\r\n",
+		"REGEDIT4\r\n\"a\"=\"b\"\r\n",
+		"REGEDIT4\r\n[a\r\n",
+		"REGEDIT4\r\n[a]\r\n\"a\"=\"b\r\n",
+		"REGEDIT4\r\n[a]\r\n\"a\"=dword:123456789\r\n",
+		"REGEDIT4\r\n[a]\r\n\"a\"=hex:1,2,345\r\n",
+		"REGEDIT4\r\n[a]\r\n\"a\"=hex(x):01\r\n",
+		"REGEDIT4\r\n[a]\r\n\"a\"\r\n",
+	}
+	for _, tt := range tests {
+		if _, err := ParseReg(bytes.NewReader([]byte(tt))); err == nil {
+			t.Errorf("ParseReg(%q) error = nil", tt)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package registry
 
 import (
+	"encoding/binary"
 	"io"
 	"os"
 )
@@ -17,48 +18,108 @@ type Registry struct {
 	hiveBins []bin
 
 	createdByOpenKey bool
+
+	controlSet string // value of the Select key naming the CurrentControlSet, see WithControlSet
+
+	cells *cellAllocator // set if opened for writing
+	file  *hiveFile      // set if opened for writing
 }
 
-// Open opens a registry file
+// Open opens a registry file for reading
 func Open(f string) (Registry, error) {
-	fp, err := os.Open(f)
+	return OpenFile(f, os.O_RDONLY)
+}
+
+// OpenFile opens a registry file with the given flag, os.O_RDONLY or os.O_RDWR.
+// Registries opened with os.O_RDWR can be changed with the Set* and Delete* functions.
+func OpenFile(f string, flag int) (Registry, error) {
+	if flag&os.O_WRONLY != 0 {
+		flag = flag&^os.O_WRONLY | os.O_RDWR
+	}
+	fp, err := os.OpenFile(f, flag, 0)
 	if err != nil {
 		return Registry{}, err
 	}
 
+	r, err := openFile(fp, f, flag&os.O_RDWR != 0)
+	if err != nil {
+		fp.Close()
+		return Registry{}, err
+	}
+	r.fp = fp
+	return r, nil
+}
+
+// openFile opens the registry in fp. Writable registries keep the changes
+// in memory until committed. If the file was partly written, the changes
+// are recovered from its transaction log.
+func openFile(fp *os.File, name string, writable bool) (Registry, error) {
 	h := newHeader(fp)
+	err := h.Read()
+	recovery := err == errBadSequenceNumber || err == errInvalidXOR
+	_, err = fp.Seek(0, io.SeekStart)
+	if err != nil {
+		return Registry{}, err
+	}
 
-	err = h.Read()
+	if !writable && !recovery {
+		return open(fp, false)
+	}
+
+	f, err := newHiveFile(fp, name)
 	if err != nil {
-		return Registry{}, errorW{function: "Open h.Read", err: ErrBadRegistry, cause: err}
+		return Registry{}, err
+	}
+	if recovery {
+		err = recoverLog(f)
+		if err != nil {
+			return Registry{}, errorW{function: "Open recoverLog", err: ErrBadRegistry, cause: err}
+		}
+		f.Seek(0, io.SeekStart)
 	}
 
-	bins, err := getHiveBins(fp)
+	r, err := open(f, writable)
 	if err != nil {
-		return Registry{}, errorW{function: "Open getHiveBins", err: ErrBadRegistry, cause: err}
+		return Registry{}, err
+	}
+	if writable {
+		r.file = f
 	}
+	return r, nil
+}
 
-	var root *namedKey
-	for _, bin := range bins {
-		nk, ok := bin.cell.data.(*namedKey)
-		if ok && nk.name == "ROOT" {
-			root = nk
-			root.binOffset = bin.offset + int64(bin.header.size)
-			break
-		}
+func open(rws io.ReadWriteSeeker, writable bool) (Registry, error) {
+	h := newHeader(rws)
+
+	err := h.Read()
+	if err != nil {
+		return Registry{}, errorW{function: "Open h.Read", err: ErrBadRegistry, cause: err}
 	}
 
-	if root == nil {
+	bins, err := getHiveBins(rws)
+	if err != nil {
+		return Registry{}, errorW{function: "Open getHiveBins", err: ErrBadRegistry, cause: err}
+	}
+
+	root, err := readNamedKeyAt(rws, h, int64(h.rootOffset))
+	if err != nil || !root.isRoot() {
 		return Registry{}, errorW{function: "Open findRoot", err: ErrBadRegistry, cause: errRootNotFound}
 	}
 
-	return Registry{
+	r := Registry{
 		header:   h,
 		hiveBins: bins,
 		root:     root,
-		fp:       fp,
-		rws:      fp,
-	}, nil
+		rws:      rws,
+	}
+
+	if writable {
+		r.cells, err = newCellAllocator(rws, h)
+		if err != nil {
+			return Registry{}, errorW{function: "Open newCellAllocator", err: ErrBadRegistry, cause: err}
+		}
+	}
+	return r, nil
 }
 
 // OpenKey opens a new key in file located at path
@@ -72,17 +133,63 @@ func OpenKey(file, path string) (Key, error) {
 }
 
 // OpenKey opens a new key located at path
-// If path is empty, it is returned the root key
-func (r Registry) OpenKey(path string) (Key, error) {
+// If path is empty, it is returned the root key.
+// access is the one of Key.OpenSubKey
+func (r Registry) OpenKey(path string, access ...uint32) (Key, error) {
 	k := newKey(r, r.rws, r.root)
 	if path == "" {
 		return k, nil
 	}
-	return k.OpenSubKey(path)
+	return k.OpenSubKey(path, access...)
+}
+
+// OpenKeyAt opens the key stored in the cell at offset.
+// The offset is relative to the start of the hive bins data (the first "hbin"),
+// like the offsets stored in the registry and reported by Key.Offset.
+// If offset does not hold an allocated "nk" cell, ErrInvalidOffset is returned.
+func (r Registry) OpenKeyAt(offset int64) (Key, error) {
+	nk, err := readNamedKeyAt(r.rws, r.header, offset)
+	if err != nil {
+		return Key{}, err
+	}
+	return newKey(r, r.rws, nk), nil
 }
 
-// Close closes registry file
+// readNamedKeyAt reads and validates the named key stored in the cell at offset.
+// Cells are aligned on 8 bytes.
+func readNamedKeyAt(rws io.ReadWriteSeeker, h *header, offset int64) (*namedKey, error) {
+	if offset%8 != 0 || offset < hiveBinsDataStart || offset+cellHeaderSize+2 > int64(h.binSize) {
+		return nil, ErrInvalidOffset
+	}
+
+	_, err := rws.Seek(hiveBinsOffset+offset, io.SeekStart)
+	if err != nil {
+		return nil, errorW{err: ErrCorruptRegistry, cause: err, function: "readNamedKeyAt r.Seek"}
+	}
+	b := make([]byte, cellHeaderSize+2)
+	_, err = io.ReadFull(rws, b)
+	if err != nil {
+		return nil, errorW{err: ErrCorruptRegistry, cause: err, function: "readNamedKeyAt io.ReadFull"}
+	}
+	// allocated cells have a negative size
+	if int32(binary.LittleEndian.Uint32(b)) >= 0 || string(b[cellHeaderSize:]) != namedKeySig {
+		return nil, ErrInvalidOffset
+	}
+
+	nk := newNamedKey(rws, hiveBinsOffset+cellHeaderSize, hiveBinsOffset+cellHeaderSize+offset)
+	return nk, nk.Read()
+}
+
+// Close closes registry file.
+// The changes made to writable registries are committed first.
 func (r Registry) Close() error {
+	if r.file != nil {
+		err := r.Commit()
+		if err != nil {
+			r.fp.Close()
+			return err
+		}
+	}
 	if r.fp != nil {
 		return r.fp.Close()
 	}
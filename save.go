@@ -0,0 +1,33 @@
+package registry
+
+import (
+	"io"
+	"time"
+)
+
+// SaveOptions are the options of Key.SaveAs
+type SaveOptions struct {
+	// ResetTimestamps sets the last write time of the saved keys to the
+	// time of the save instead of keeping the original ones.
+	ResetTimestamps bool
+}
+
+// SaveAs writes key k, its values and subkeys to w as a new hive
+// whose root key is a copy of k, like the Windows RegSaveKey function.
+// Class names and security descriptors are kept. opts may be nil.
+func (k Key) SaveAs(w io.Writer, opts *SaveOptions) error {
+	b, err := newHiveBuilder(nil)
+	if err != nil {
+		return err
+	}
+	if opts != nil && opts.ResetTimestamps {
+		b.lastModified = filetime(time.Now())
+	}
+
+	off, err := b.copyKey(k, k.Name(), noOffset)
+	if err != nil {
+		return err
+	}
+	_, err = b.writeTo(w, off)
+	return err
+}
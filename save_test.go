@@ -0,0 +1,109 @@
+package registry
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestKey_SaveAs(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	// a key with a class name
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := r.OpenKey(`SOFTWARE\Microsoft`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, _, err = k.CreateKey(`Vendor\WithClass`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	class := utf16LEFromString("Class")
+	k.nk.classNameOffset, err = r.cells.alloc(len(class))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.cells.writeCell(k.nk.classNameOffset, class); err != nil {
+		t.Fatal(err)
+	}
+	k.nk.classNameSize = uint16(len(class))
+	if err := k.writeNamedKey(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	k, err = r.OpenKey(`SOFTWARE\Microsoft`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, reset := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := k.SaveAs(&buf, &SaveOptions{ResetTimestamps: reset}); err != nil {
+			t.Fatalf("Key.SaveAs() error = %v", err)
+		}
+		saved := filepath.Join(filepath.Dir(file), "saved.dat")
+		if err := ioutil.WriteFile(saved, buf.Bytes(), 0666); err != nil {
+			t.Fatal(err)
+		}
+		s, err := OpenFile(saved, os.O_RDWR)
+		if err != nil {
+			t.Fatalf("OpenFile() of saved hive error = %v", err)
+		}
+
+		root, err := s.OpenKey("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if root.Name() != "Microsoft" || !root.nk.isRoot() {
+			t.Errorf("root = %v %#x", root.Name(), root.nk.flags)
+		}
+		if reset == (root.nk.lastModified == k.nk.lastModified) {
+			t.Errorf("reset %v: last write time = %v, original %v", reset, root.nk.lastModified, k.nk.lastModified)
+		}
+
+		want := map[string][]Value{}
+		err = k.walk("", func(path string, k Key) error {
+			values, err := k.ReadValues(-1)
+			want[path] = values
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := testTree(t, s)
+		if len(got) != len(want) {
+			t.Errorf("saved hive has %v keys, want %v", len(got), len(want))
+		}
+		for path, values := range want {
+			if !reflect.DeepEqual(got[path], values) {
+				t.Errorf("values of %v = %v, want %v", path, got[path], values)
+			}
+		}
+
+		c, err := s.OpenKey(`Vendor\WithClass`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := readCellData(c.rws, c.nk.binOffset, c.nk.classNameOffset, uint32(c.nk.classNameSize))
+		if err != nil || !bytes.Equal(b, class) {
+			t.Errorf("class name = %q %v", b, err)
+		}
+		s.Close()
+	}
+}
@@ -0,0 +1,322 @@
+package registry
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// SearchTarget selects which parts of a registry are searched
+type SearchTarget uint8
+
+const (
+	// SearchKeyNames matches key names
+	SearchKeyNames SearchTarget = 1 << iota
+	// SearchValueNames matches value names
+	SearchValueNames
+	// SearchValueData matches value data
+	SearchValueData
+
+	// SearchAll matches key names, value names and value data
+	SearchAll = SearchKeyNames | SearchValueNames | SearchValueData
+)
+
+// SearchQuery describes what Registry.Search looks for.
+// If both Regexp and Bytes are set, a match of either one is reported.
+type SearchQuery struct {
+	// Regexp is matched against names, string data and the
+	// ASCII and UTF-16LE representations of binary data
+	Regexp *regexp.Regexp
+
+	// Bytes is searched as is and widened to UTF-16LE, each byte
+	// followed by a zero byte
+	Bytes []byte
+
+	// Targets selects what is searched. If 0, SearchAll is used
+	Targets SearchTarget
+
+	// Context is the number of bytes kept on each side of a match
+	Context int
+}
+
+// SearchMatch is a single match found by Registry.Search
+type SearchMatch struct {
+	Path      string       // full path of the key
+	ValueName string       // name of the value. Empty if Target is SearchKeyNames
+	Type      uint32       // type of the value. Not set if Target is SearchKeyNames
+	Target    SearchTarget // what matched
+
+	// Offset of the match. For names it is relative to the name,
+	// for data it is relative to the raw value data
+	Offset  int
+	Length  int
+	Context []byte // match with Context bytes on each side
+}
+
+// Search walks every key of registry r and returns all matches of q
+func (r Registry) Search(q SearchQuery) ([]SearchMatch, error) {
+	var matches []SearchMatch
+	err := r.SearchFunc(q, func(m SearchMatch) error {
+		matches = append(matches, m)
+		return nil
+	})
+	return matches, err
+}
+
+// SearchFunc walks every key of registry r and calls fn for each match of q.
+// If fn returns an error the search stops and that error is returned.
+func (r Registry) SearchFunc(q SearchQuery, fn func(SearchMatch) error) error {
+	root, err := r.OpenKey("")
+	if err != nil {
+		return err
+	}
+	if q.Targets == 0 {
+		q.Targets = SearchAll
+	}
+
+	s := searcher{q: q, fn: fn}
+	if len(q.Bytes) > 0 {
+		s.wide = widen(q.Bytes)
+	}
+	return root.walk("", s.key)
+}
+
+// widen encodes each byte of b as a UTF-16LE character
+func widen(b []byte) []byte {
+	w := make([]byte, 0, 2*len(b))
+	for _, c := range b {
+		w = append(w, c, 0)
+	}
+	return w
+}
+
+type searcher struct {
+	q    SearchQuery
+	fn   func(SearchMatch) error
+	wide []byte // q.Bytes in UTF-16LE
+}
+
+func (s searcher) key(path string, k Key) error {
+	if s.q.Targets&SearchKeyNames != 0 && path != "" {
+		m := SearchMatch{Path: path, Target: SearchKeyNames}
+		err := s.name(m, k.nk.name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.q.Targets&(SearchValueNames|SearchValueData) == 0 {
+		return nil
+	}
+
+	list := k.nk.values
+	for i := 0; i < list.Len(); i++ {
+		vk, err := list.Value(uint(i))
+		if err != nil {
+			return err
+		}
+		m := SearchMatch{Path: path, ValueName: vk.name, Type: vk.dataType}
+
+		if s.q.Targets&SearchValueNames != 0 {
+			m.Target = SearchValueNames
+			err = s.name(m, vk.name)
+			if err != nil {
+				return err
+			}
+		}
+		if s.q.Targets&SearchValueData != 0 && len(vk.raw) > 0 {
+			m.Target = SearchValueData
+			err = s.data(m, vk.raw)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reporter returns a function emitting the matches of b, once per offset
+func (s searcher) reporter(m SearchMatch, b []byte) func(off, n int) error {
+	found := map[int]bool{}
+	return func(off, n int) error {
+		if found[off] {
+			return nil
+		}
+		found[off] = true
+		return s.emit(m, b, off, n)
+	}
+}
+
+func (s searcher) name(m SearchMatch, name string) error {
+	report := s.reporter(m, []byte(name))
+	if s.q.Regexp != nil {
+		for _, loc := range s.q.Regexp.FindAllStringIndex(name, -1) {
+			err := report(loc[0], loc[1]-loc[0])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if len(s.q.Bytes) > 0 {
+		for _, off := range indexAll([]byte(name), s.q.Bytes) {
+			err := report(off, len(s.q.Bytes))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s searcher) data(m SearchMatch, raw []byte) error {
+	report := s.reporter(m, raw)
+
+	if s.q.Regexp != nil {
+		// string data is only meaningful as UTF-16LE, everything
+		// else is also tried as ASCII and at odd offsets
+		views := []int{0}
+		switch m.Type {
+		case REG_SZ, REG_EXPAND_SZ, REG_LINK, REG_MULTI_SZ:
+		default:
+			views = append(views, 1)
+			for _, loc := range s.q.Regexp.FindAllIndex(raw, -1) {
+				err := report(loc[0], loc[1]-loc[0])
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for _, start := range views {
+			text, offsets := utf16View(raw, start)
+			for _, loc := range s.q.Regexp.FindAllStringIndex(text, -1) {
+				if loc[0] == loc[1] {
+					continue
+				}
+				err := report(offsets[loc[0]], offsets[loc[1]]-offsets[loc[0]])
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if len(s.q.Bytes) > 0 {
+		for _, needle := range [][]byte{s.q.Bytes, s.wide} {
+			for _, off := range indexAll(raw, needle) {
+				err := report(off, len(needle))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s searcher) emit(m SearchMatch, b []byte, off, n int) error {
+	start, end := off-s.q.Context, off+n+s.q.Context
+	if start < 0 {
+		start = 0
+	}
+	if end > len(b) {
+		end = len(b)
+	}
+	m.Offset = off
+	m.Length = n
+	m.Context = append([]byte(nil), b[start:end]...)
+	return s.fn(m)
+}
+
+// walk calls fn for k and every descendant of k, depth first.
+// path is the path of k, used to build the path of the descendants
+func (k Key) walk(path string, fn func(path string, k Key) error) error {
+	err := fn(path, k)
+	if err != nil || k.nk.numberOfSubKeys == 0 {
+		return err
+	}
+
+	list, err := k.subkeys()
+	if err != nil {
+		return err
+	}
+	els, err := list.allElements()
+	if err != nil {
+		return err
+	}
+
+	for _, el := range els {
+		if el.namedKey == nil {
+			continue
+		}
+		sub := newKey(k.registry, k.rws, el.namedKey)
+		subPath := el.namedKey.name
+		if path != "" {
+			subPath = path + string(separator) + subPath
+		}
+		err = sub.walk(subPath, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexAll returns the offsets of all occurrences of sep in b
+func indexAll(b, sep []byte) (r []int) {
+	if len(sep) == 0 {
+		return nil
+	}
+	for off := 0; off+len(sep) <= len(b); {
+		i := bytes.Index(b[off:], sep)
+		if i < 0 {
+			break
+		}
+		r = append(r, off+i)
+		off += i + 1
+	}
+	return r
+}
+
+// utf16View decodes b as UTF-16LE starting at byte start.
+// offsets maps each byte of the returned string to its offset in b;
+// offsets[len(text)] is the offset after the last decoded character.
+// Invalid surrogates are decoded as utf8.RuneError.
+func utf16View(b []byte, start int) (text string, offsets []int) {
+	var sb strings.Builder
+	offsets = make([]int, 0, len(b))
+	buf := make([]byte, utf8.UTFMax)
+
+	i := start
+	for i+1 < len(b) {
+		c := rune(uint16(b[i]) | uint16(b[i+1])<<8)
+		size := 2
+		if utf16.IsSurrogate(c) && i+3 < len(b) {
+			c2 := rune(uint16(b[i+2]) | uint16(b[i+3])<<8)
+			if r := utf16.DecodeRune(c, c2); r != utf8.RuneError {
+				c = r
+				size = 4
+			}
+		}
+		n := utf8.EncodeRune(buf, c)
+		sb.Write(buf[:n])
+		for j := 0; j < n; j++ {
+			offsets = append(offsets, i)
+		}
+		i += size
+	}
+	offsets = append(offsets, i)
+	return sb.String(), offsets
+}
+
+// utf16LEFromString encodes s as UTF-16LE without terminator
+func utf16LEFromString(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, len(u)*2)
+	for i, c := range u {
+		b[2*i] = byte(c)
+		b[2*i+1] = byte(c >> 8)
+	}
+	return b
+}
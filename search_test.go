@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestRegistry_Search(t *testing.T) {
+	tests := []struct {
+		name  string
+		query SearchQuery
+		want  SearchMatch
+	}{
+		{
+			name:  "key name",
+			query: SearchQuery{Regexp: regexp.MustCompile(`^NativeMessagingHosts$`), Targets: SearchKeyNames},
+			want:  SearchMatch{Path: `SOFTWARE\Google\Chrome\NativeMessagingHosts`, Target: SearchKeyNames, Offset: 0, Length: 20, Context: []byte("NativeMessagingHosts")},
+		},
+		{
+			name:  "value name",
+			query: SearchQuery{Regexp: regexp.MustCompile(`ImplicitInk`), Targets: SearchValueNames},
+			want:  SearchMatch{Path: `SOFTWARE\Microsoft\InputPersonalization`, ValueName: "RestrictImplicitInkCollection", Type: REG_DWORD, Target: SearchValueNames, Offset: 8, Length: 11, Context: []byte("ImplicitInk")},
+		},
+		{
+			name:  "string data",
+			query: SearchQuery{Regexp: regexp.MustCompile(`BrowserCore\\manifest`), Targets: SearchValueData, Context: 2},
+			want:  SearchMatch{Path: `SOFTWARE\Google\Chrome\NativeMessagingHosts\com.microsoft.browsercore`, ValueName: "(default)", Type: REG_SZ, Target: SearchValueData, Offset: 68, Length: 40, Context: utf16LEFromString(`\BrowserCore\manifest.`)},
+		},
+		{
+			name:  "multi string data as bytes",
+			query: SearchQuery{Bytes: []byte("pt-PT"), Targets: SearchValueData},
+			want:  SearchMatch{Path: `Control Panel\International\User Profile`, ValueName: "Languages", Type: REG_MULTI_SZ, Target: SearchValueData, Offset: 0, Length: 10, Context: utf16LEFromString("pt-PT")},
+		},
+	}
+	r, err := Open("testdata/NTUSER.DAT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.Search(tt.query)
+			if err != nil {
+				t.Fatalf("Registry.Search() error = %v", err)
+			}
+			for _, m := range got {
+				if m.Path == tt.want.Path && m.ValueName == tt.want.ValueName {
+					if m.Type != tt.want.Type || m.Target != tt.want.Target || m.Offset != tt.want.Offset ||
+						m.Length != tt.want.Length || string(m.Context) != string(tt.want.Context) {
+						t.Errorf("Registry.Search()\ngot  = %+v\nwant = %+v", m, tt.want)
+					}
+					return
+				}
+			}
+			t.Errorf("Registry.Search() = %+v, want match %+v", got, tt.want)
+		})
+	}
+}
+
+func TestRegistry_SearchWideBytes(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	k, err := r.OpenKey("Environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.SetStringValue("Latin1", "café"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 0xe9 is not UTF-8, it is searched as the character é
+	got, err := r.Search(SearchQuery{Bytes: []byte{'f', 0xe9}, Targets: SearchValueData})
+	if err != nil {
+		t.Fatalf("Registry.Search() error = %v", err)
+	}
+	if len(got) != 1 || got[0].ValueName != "Latin1" || got[0].Offset != 4 || got[0].Length != 4 {
+		t.Errorf("Registry.Search() = %+v", got)
+	}
+}
+
+func TestRegistry_SearchNameOnce(t *testing.T) {
+	r, err := Open("testdata/NTUSER.DAT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// a name matched by both Regexp and Bytes is reported once
+	q := SearchQuery{Regexp: regexp.MustCompile(`^Environment$`), Bytes: []byte("Environment"), Targets: SearchKeyNames}
+	got, err := r.Search(q)
+	if err != nil {
+		t.Fatalf("Registry.Search() error = %v", err)
+	}
+	n := 0
+	for _, m := range got {
+		if m.Path == "Environment" {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("Registry.Search() reported Environment %v times, want 1", n)
+	}
+}
@@ -1,6 +1,16 @@
 package registry
 
+import (
+	"encoding/binary"
+	"io"
+)
+
 type securityKey struct {
+	rws io.ReadWriteSeeker
+
+	binOffset int64
+	offset    uint32
+
 	signature string // must be equal to "sk"
 
 	previousKeyOffset uint32
@@ -9,7 +19,49 @@ type securityKey struct {
 	referenceCount uint32
 
 	ntSecurityDescriptorSize uint32
-	ntSecurityDescriptor     string
+	ntSecurityDescriptor     []byte
+}
+
+func newSecurityKey(rws io.ReadWriteSeeker, binOffset int64, offset uint32) *securityKey {
+	return &securityKey{
+		rws:       rws,
+		binOffset: binOffset,
+		offset:    offset,
+	}
+}
+
+// Read reads the "sk" record and its security descriptor
+func (sk *securityKey) Read() error {
+	r := sk.rws
+
+	_, err := r.Seek(sk.binOffset+int64(sk.offset), io.SeekStart)
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "securityKey.Read() r.Seek"}
+	}
+	b := make([]byte, 20)
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "securityKey.Read() io.ReadFull"}
+	}
+
+	sk.signature = string(b[:2])
+	// b[2:4] = reserved
+	sk.previousKeyOffset = binary.LittleEndian.Uint32(b[4:8])
+	sk.nextKeyOffset = binary.LittleEndian.Uint32(b[8:12])
+	sk.referenceCount = binary.LittleEndian.Uint32(b[12:16])
+	sk.ntSecurityDescriptorSize = binary.LittleEndian.Uint32(b[16:20])
+
+	err = sk.validate()
+	if err != nil {
+		return err
+	}
+
+	sk.ntSecurityDescriptor = make([]byte, sk.ntSecurityDescriptorSize)
+	_, err = io.ReadFull(r, sk.ntSecurityDescriptor)
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "securityKey.Read() io.ReadFull"}
+	}
+	return nil
 }
 
 func (sk securityKey) validate() error {
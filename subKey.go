@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"strings"
 )
 
 type subKeyList struct {
@@ -71,39 +72,31 @@ func (skl *subKeyList) Read() (err error) {
 	return skl.validate()
 }
 
-func (skl *subKeyList) subkeyNames(n int) (names []string, err error) {
-	max := int(skl.numberElements)
-	if n < 0 {
-		n = max
-	}
-	if n > max {
-		n = max
-	}
-
-	names = make([]string, n)
-	k := 0
-	for i := 0; i < n; i++ {
-		el := skl.elements[k]
-		err := el.ReadElement()
+// find returns the named key with the given name, comparing case insensitively.
+// If no key is found it returns ErrNotExist
+func (skl *subKeyList) find(name string) (*namedKey, error) {
+	hash := lhSubKeyHash(name)
+	for _, e := range skl.elements {
+		if e.signature == subKeyList2Sig && e.hashValue != hash {
+			continue
+		}
+		err := e.ReadElement()
 		if err != nil {
 			return nil, err
 		}
-		if el.namedKey != nil {
-			names[i] = el.namedKey.name
-		} else if el.subKeyList != nil {
-			el.subKeyList.rws = skl.rws
-			nms, err := el.subKeyList.subkeyNames(max - i)
-			if err != nil {
-				return nil, err
-			}
-			for _, v := range nms {
-				names[i] = v
-				i++
+		if e.subKeyList != nil {
+			e.subKeyList.rws = skl.rws
+			nk, err := e.subKeyList.find(name)
+			if err != ErrNotExist {
+				return nk, err
 			}
+			continue
+		}
+		if strings.EqualFold(e.namedKey.name, name) {
+			return e.namedKey, nil
 		}
-		k++
 	}
-	return names, nil
+	return nil, ErrNotExist
 }
 
 func (skl *subKeyList) allElements() (el []*subKeyElement, err error) {
@@ -180,7 +173,7 @@ func (el *subKeyElement) Read() error {
 
 func (el *subKeyElement) ReadElement() error {
 	switch el.signature {
-	case "lf", "lh":
+	case "lf", "lh", "li":
 		el.namedKey = newNamedKey(
 			el.rws,
 			el.binOffset,
@@ -190,8 +183,7 @@ func (el *subKeyElement) ReadElement() error {
 		if err != nil {
 			return err
 		}
-		hash := lhSubKeyHash(el.namedKey.name)
-		if hash != el.hashValue {
+		if !el.validHash() {
 			return errorW{err: ErrCorruptRegistry, cause: errInvalidHash, function: "subKeyElement.ReadElement() hash comparision"}
 		}
 	case "ri":
@@ -210,3 +202,15 @@ func (el *subKeyElement) ReadElement() error {
 
 	return nil
 }
+
+// validHash checks the element hash against the name of the read named key.
+// "li" elements have no hash
+func (el *subKeyElement) validHash() bool {
+	switch el.signature {
+	case "lf":
+		return lfSubKeyHash(el.namedKey.name) == el.hashValue
+	case "lh":
+		return lhSubKeyHash(el.namedKey.name) == el.hashValue
+	}
+	return true
+}
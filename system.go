@@ -0,0 +1,303 @@
+package registry
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// Names of the root keys of a System
+const (
+	HKEY_LOCAL_MACHINE = "HKEY_LOCAL_MACHINE"
+	HKEY_USERS         = "HKEY_USERS"
+	HKEY_CURRENT_USER  = "HKEY_CURRENT_USER"
+)
+
+// rootAliases are the short names of the root keys
+var rootAliases = map[string]string{
+	"HKLM": HKEY_LOCAL_MACHINE,
+	"HKU":  HKEY_USERS,
+	"HKCU": HKEY_CURRENT_USER,
+}
+
+// machineHives are the hives of System32\config and where they are loaded
+var machineHives = [][2]string{
+	{"SYSTEM", HKEY_LOCAL_MACHINE + `\SYSTEM`},
+	{"SOFTWARE", HKEY_LOCAL_MACHINE + `\SOFTWARE`},
+	{"SAM", HKEY_LOCAL_MACHINE + `\SAM`},
+	{"SECURITY", HKEY_LOCAL_MACHINE + `\SECURITY`},
+	{"DEFAULT", HKEY_USERS + `\.DEFAULT`},
+}
+
+// systemRoots are the directories where Windows may be installed
+var systemRoots = []string{"Windows", "WINNT"}
+
+const (
+	classesSuffix = "_Classes"         // suffix of the SID of the hives of UsrClass.dat
+	userClasses   = `Software\Classes` // key of HKEY_CURRENT_USER linked to the UsrClass.dat hive
+	userSIDPrefix = "S-1-5-21-"        // prefix of the SIDs of user accounts
+)
+
+// System is the registry of an offline Windows installation: the hives
+// of the machine and of its users, loaded where Windows loads them.
+type System struct {
+	// CurrentUser is the SID of the user of HKEY_CURRENT_USER.
+	// OpenSystem sets it when the system has a single user account.
+	CurrentUser string
+
+	// Errors are the errors of the user hives that could not be opened,
+	// by the path they would be loaded at. OpenSystem skips these hives.
+	Errors map[string]error
+
+	hives []systemHive
+}
+
+// systemHive is a hive loaded at path, like HKEY_LOCAL_MACHINE\SOFTWARE
+type systemHive struct {
+	path     string
+	registry Registry
+}
+
+// OpenSystem opens the registry of the Windows volume mounted at root.
+// It loads the hives of System32\config and the NTUSER.DAT and
+// UsrClass.dat of the profiles listed in the SOFTWARE hive, at
+// HKEY_USERS\<SID> and HKEY_USERS\<SID>_Classes. Missing hives are
+// skipped, like broken user hives reported in System.Errors; broken
+// hives of the machine fail OpenSystem. File names are compared case
+// insensitively.
+func OpenSystem(root string) (*System, error) {
+	var config string
+	for _, dir := range systemRoots {
+		local, err := localPath(root, dir+`\System32\config`)
+		if err == nil {
+			config = local
+			break
+		}
+	}
+	if config == "" {
+		return nil, ErrNotExist
+	}
+
+	s := &System{}
+	for _, h := range machineHives {
+		err := s.load(config, h[0], h[1])
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	software, ok := s.hive(HKEY_LOCAL_MACHINE + `\SOFTWARE`)
+	if !ok {
+		return s, nil
+	}
+	err := s.loadUsers(root, software)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadUsers loads the hives of the profiles of ProfileList
+func (s *System) loadUsers(root string, software Registry) error {
+	profiles, err := software.OpenKey(`Microsoft\Windows NT\CurrentVersion\ProfileList`)
+	if err == ErrNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	env := NewEnvironment()
+	env.LoadSoftware(software, "")
+
+	sids, err := profiles.ReadSubKeyNames(-1)
+	if err != nil {
+		return err
+	}
+	var users []string
+	for _, sid := range sids {
+		profile, err := profiles.OpenSubKey(sid)
+		if err != nil {
+			return err
+		}
+		dir, _, err := profile.GetExpandedStringValue("ProfileImagePath", env)
+		if err != nil {
+			continue
+		}
+		local, err := localPath(root, dir)
+		if err != nil {
+			continue
+		}
+		user := HKEY_USERS + `\` + sid
+		if err = s.load(local, "NTUSER.DAT", user); err != nil {
+			s.skip(user, err)
+		}
+		classes, err := localPath(local, `AppData\Local\Microsoft\Windows`)
+		if err == nil {
+			err = s.load(classes, "UsrClass.dat", user+classesSuffix)
+			if err != nil {
+				s.skip(user+classesSuffix, err)
+			}
+		}
+		if _, ok := s.hive(HKEY_USERS + `\` + sid); ok && strings.HasPrefix(sid, userSIDPrefix) {
+			users = append(users, sid)
+		}
+	}
+	if len(users) == 1 {
+		s.CurrentUser = users[0]
+	}
+	return nil
+}
+
+// load loads the hive file name of directory dir at path, if it exists
+func (s *System) load(dir, name, path string) error {
+	local, err := localPath(dir, name)
+	if err != nil {
+		return nil
+	}
+	r, err := Open(local)
+	if os.IsNotExist(err) || os.IsPermission(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.hives = append(s.hives, systemHive{path: path, registry: r})
+	return nil
+}
+
+// skip records the error of the user hive not loaded at path
+func (s *System) skip(path string, err error) {
+	if s.Errors == nil {
+		s.Errors = map[string]error{}
+	}
+	s.Errors[path] = err
+}
+
+// hive returns the registry loaded at path
+func (s *System) hive(path string) (Registry, bool) {
+	for _, h := range s.hives {
+		if strings.EqualFold(h.path, path) {
+			return h.registry, true
+		}
+	}
+	return Registry{}, false
+}
+
+// Close closes the hives of the system
+func (s *System) Close() error {
+	var err error
+	for _, h := range s.hives {
+		if e := h.registry.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	s.hives = nil
+	return err
+}
+
+// Hives returns the paths where hives are loaded, sorted,
+// like HKEY_LOCAL_MACHINE\SOFTWARE
+func (s *System) Hives() []string {
+	paths := make([]string, len(s.hives))
+	for i, h := range s.hives {
+		paths[i] = h.path
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Users returns the SIDs of the users whose NTUSER.DAT is loaded
+func (s *System) Users() []string {
+	var sids []string
+	for _, h := range s.hives {
+		sid, ok := trimRegRoot(h.path, HKEY_USERS)
+		if ok && sid != ".DEFAULT" && !strings.HasSuffix(sid, classesSuffix) {
+			sids = append(sids, sid)
+		}
+	}
+	sort.Strings(sids)
+	return sids
+}
+
+// Hive returns the registry holding the key at path, like
+// HKLM\SOFTWARE\Microsoft, and the path of the key in that registry.
+// If no hive is loaded at path, Hive returns ErrNotExist.
+func (s *System) Hive(path string) (Registry, string, error) {
+	path, err := s.fullPath(path)
+	if err != nil {
+		return Registry{}, "", err
+	}
+	for _, h := range s.hives {
+		if sub, ok := trimRegRoot(path, h.path); ok {
+			return h.registry, sub, nil
+		}
+	}
+	return Registry{}, "", ErrNotExist
+}
+
+// OpenKey opens the key at path, which starts with the name of a root
+// key, like HKEY_LOCAL_MACHINE or HKLM. Keys above the hives, like
+// HKEY_LOCAL_MACHINE itself, can not be opened. access is the one of
+// Key.OpenSubKey.
+func (s *System) OpenKey(path string, access ...uint32) (Key, error) {
+	r, sub, err := s.Hive(path)
+	if err != nil {
+		return Key{}, err
+	}
+	return r.OpenKey(sub, access...)
+}
+
+// fullPath returns path starting with HKEY_LOCAL_MACHINE or HKEY_USERS,
+// resolving the short names of the root keys and HKEY_CURRENT_USER
+func (s *System) fullPath(path string) (string, error) {
+	path = strings.Trim(path, string(separator))
+	root, rest := path, ""
+	if i := strings.IndexByte(path, separator); i >= 0 {
+		root, rest = path[:i], path[i+1:]
+	}
+	root = strings.ToUpper(root)
+	if alias, ok := rootAliases[root]; ok {
+		root = alias
+	}
+
+	if root != HKEY_CURRENT_USER {
+		return joinPath(root, rest), nil
+	}
+	if s.CurrentUser == "" {
+		return "", ErrNotExist
+	}
+	user := HKEY_USERS + `\` + s.CurrentUser
+	if classes, ok := trimRegRoot(rest, userClasses); ok {
+		if _, ok := s.hive(user + classesSuffix); ok {
+			return joinPath(user+classesSuffix, classes), nil
+		}
+	}
+	return joinPath(user, rest), nil
+}
+
+// machineClasses is the key of HKEY_LOCAL_MACHINE merged in HKEY_CLASSES_ROOT
+const machineClasses = HKEY_LOCAL_MACHINE + `\SOFTWARE\Classes`
+
+// ClassesRoot returns the view of HKEY_CLASSES_ROOT, merging the classes
+// of the current user, HKCU\Software\Classes, with the classes of the
+// machine, HKLM\SOFTWARE\Classes. The classes of the user take precedence.
+// Without a current user, only the classes of the machine are returned.
+func (s *System) ClassesRoot() (MergedKey, error) {
+	var keys []Key
+	for _, path := range []string{HKEY_CURRENT_USER + `\` + userClasses, machineClasses} {
+		k, err := s.OpenKey(path)
+		if err == ErrNotExist {
+			continue
+		}
+		if err != nil {
+			return MergedKey{}, err
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return MergedKey{}, ErrNotExist
+	}
+	return NewMergedKey(keys...), nil
+}
@@ -0,0 +1,204 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testSID = "S-1-5-21-1-2-3-1001"
+
+// testSystem creates the files of an offline Windows installation,
+// with the test NTUSER.DAT as the hive of user testSID
+func testSystem(t *testing.T) (string, func()) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	root := filepath.Dir(file)
+
+	config := filepath.Join(root, "windows", "system32", "CONFIG")
+	profile := filepath.Join(root, "Users", "test")
+	classes := filepath.Join(profile, "AppData", "Local", "Microsoft", "Windows")
+	for _, dir := range []string{config, classes} {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Rename(file, filepath.Join(profile, "ntuser.dat")); err != nil {
+		t.Fatal(err)
+	}
+
+	hives := map[string]func(Key) error{
+		filepath.Join(config, "SOFTWARE"): func(k Key) error {
+			v, _, err := k.CreateKey(`Microsoft\Windows NT\CurrentVersion`)
+			if err != nil {
+				return err
+			}
+			if err = v.SetStringValue("SystemRoot", `C:\Windows`); err != nil {
+				return err
+			}
+			p, _, err := v.CreateKey(`ProfileList\` + testSID)
+			if err != nil {
+				return err
+			}
+			if err = p.SetExpandStringValue("ProfileImagePath", `%SystemDrive%\Users\Test`); err != nil {
+				return err
+			}
+			// profiles without files are skipped
+			p, _, err = v.CreateKey(`ProfileList\S-1-5-21-1-2-3-1002`)
+			if err != nil {
+				return err
+			}
+			if err = p.SetExpandStringValue("ProfileImagePath", `C:\Users\removed`); err != nil {
+				return err
+			}
+			_, _, err = k.CreateKey(`Classes\.txt`)
+			return err
+		},
+		filepath.Join(config, "SYSTEM"): func(k Key) error {
+			s, _, err := k.CreateKey("Select")
+			if err != nil {
+				return err
+			}
+			if err = s.SetDWordValue("Current", 1); err != nil {
+				return err
+			}
+			_, _, err = k.CreateKey(`ControlSet001\Services`)
+			return err
+		},
+		filepath.Join(config, "DEFAULT"): func(k Key) error {
+			_, _, err := k.CreateKey("Environment")
+			return err
+		},
+		filepath.Join(classes, "UsrClass.dat"): func(k Key) error {
+			_, _, err := k.CreateKey(`.txt`)
+			return err
+		},
+	}
+	for name, fill := range hives {
+		if err := testHive(t, name, fill).Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, cleanup
+}
+
+func TestOpenSystem(t *testing.T) {
+	root, cleanup := testSystem(t)
+	defer cleanup()
+
+	s, err := OpenSystem(root)
+	if err != nil {
+		t.Fatalf("OpenSystem() error = %v", err)
+	}
+	defer s.Close()
+
+	want := []string{
+		`HKEY_LOCAL_MACHINE\SOFTWARE`,
+		`HKEY_LOCAL_MACHINE\SYSTEM`,
+		`HKEY_USERS\.DEFAULT`,
+		`HKEY_USERS\` + testSID,
+		`HKEY_USERS\` + testSID + `_Classes`,
+	}
+	if got := s.Hives(); !reflect.DeepEqual(got, want) {
+		t.Errorf("System.Hives() = %v, want %v", got, want)
+	}
+	if got := s.Users(); !reflect.DeepEqual(got, []string{testSID}) {
+		t.Errorf("System.Users() = %v", got)
+	}
+	if s.CurrentUser != testSID {
+		t.Errorf("System.CurrentUser = %v, want %v", s.CurrentUser, testSID)
+	}
+
+	tests := []struct {
+		path    string
+		hive    string
+		sub     string
+		wantErr error
+	}{
+		{path: `HKLM\SOFTWARE\Microsoft\Windows NT`, hive: `HKEY_LOCAL_MACHINE\SOFTWARE`, sub: `Microsoft\Windows NT`},
+		{path: `hkey_local_machine\system\ControlSet001`, hive: `HKEY_LOCAL_MACHINE\SYSTEM`, sub: `ControlSet001`},
+		{path: `HKLM\SYSTEM\CurrentControlSet\Services`, hive: `HKEY_LOCAL_MACHINE\SYSTEM`, sub: `CurrentControlSet\Services`},
+		{path: `HKEY_USERS\.DEFAULT\Environment`, hive: `HKEY_USERS\.DEFAULT`, sub: `Environment`},
+		{path: `HKU\` + testSID + `\Environment`, hive: `HKEY_USERS\` + testSID, sub: `Environment`},
+		{path: `HKCU\Environment`, hive: `HKEY_USERS\` + testSID, sub: `Environment`},
+		{path: `HKEY_CURRENT_USER`, hive: `HKEY_USERS\` + testSID, sub: ``},
+		{path: `HKCU\Software\Classes\.txt`, hive: `HKEY_USERS\` + testSID + `_Classes`, sub: `.txt`},
+		{path: `HKU\` + testSID + `_Classes\.txt`, hive: `HKEY_USERS\` + testSID + `_Classes`, sub: `.txt`},
+		{path: `HKLM\SAM\SAM`, wantErr: ErrNotExist},
+		{path: `HKLM`, wantErr: ErrNotExist},
+		{path: `HKEY_CLASSES_ROOT\.txt`, wantErr: ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			r, sub, err := s.Hive(tt.path)
+			if err != tt.wantErr {
+				t.Fatalf("System.Hive() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if h, _ := s.hive(tt.hive); h.root != r.root || sub != tt.sub {
+				t.Errorf("System.Hive() = %v, want %v in %v", sub, tt.sub, tt.hive)
+			}
+			if _, err := s.OpenKey(tt.path); err != nil {
+				t.Errorf("System.OpenKey() error = %v", err)
+			}
+		})
+	}
+
+	classes, err := s.ClassesRoot()
+	if err != nil {
+		t.Fatalf("System.ClassesRoot() error = %v", err)
+	}
+	if txt, err := classes.OpenSubKey(".txt"); err != nil || len(txt.Keys()) != 2 {
+		t.Errorf("System.ClassesRoot() .txt = %v, %v, want the keys of the user and the machine", txt.Keys(), err)
+	}
+
+	s.CurrentUser = ""
+	if classes, err := s.ClassesRoot(); err != nil || len(classes.Keys()) != 1 {
+		t.Errorf("System.ClassesRoot() without current user = %v, %v", classes.Keys(), err)
+	}
+	if _, err := s.OpenKey(`HKCU\Environment`); err != ErrNotExist {
+		t.Errorf("System.OpenKey() without current user error = %v, want %v", err, ErrNotExist)
+	}
+}
+
+func TestOpenSystem_BrokenUser(t *testing.T) {
+	root, cleanup := testSystem(t)
+	defer cleanup()
+	err := ioutil.WriteFile(filepath.Join(root, "Users", "test", "ntuser.dat"), []byte("broken"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenSystem(root)
+	if err != nil {
+		t.Fatalf("OpenSystem() error = %v", err)
+	}
+	defer s.Close()
+	if _, ok := s.Errors[`HKEY_USERS\`+testSID]; !ok || len(s.Errors) != 1 {
+		t.Errorf("System.Errors = %v, want the error of the hive of %v", s.Errors, testSID)
+	}
+	if got := s.Users(); len(got) != 0 || s.CurrentUser != "" {
+		t.Errorf("System.Users() = %v, CurrentUser = %v, want no user", got, s.CurrentUser)
+	}
+	if _, _, err := s.Hive(`HKU\` + testSID + `_Classes`); err != nil {
+		t.Errorf("System.Hive() of the classes of the user error = %v", err)
+	}
+
+	// broken hives of the machine are not skipped
+	config := filepath.Join(root, "windows", "system32", "CONFIG")
+	if err := ioutil.WriteFile(filepath.Join(config, "SYSTEM"), []byte("broken"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenSystem(root); err == nil {
+		t.Error("OpenSystem() with a broken SYSTEM hive error = nil")
+	}
+}
+
+func TestOpenSystem_NotWindows(t *testing.T) {
+	if _, err := OpenSystem("testdata"); err != ErrNotExist {
+		t.Errorf("OpenSystem() error = %v, want %v", err, ErrNotExist)
+	}
+}
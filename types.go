@@ -2,6 +2,12 @@ package registry
 
 const separator = '\\'
 
+const (
+	hiveBinsOffset    = 4096 // the hive bins start after the 4KB file header
+	hiveBinsDataStart = 32   // the first cell is after the 32 byte "hbin" header
+	cellHeaderSize    = 4    // cells start with their size
+)
+
 // Signatures
 const (
 	registrySig    = "regf"
@@ -3,18 +3,56 @@ package registry
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
 	"time"
 	"unicode"
 	"unicode/utf16"
 )
 
-func date(i uint64) time.Time {
-	return time.Unix(0, int64(i)*int64(time.Nanosecond))
+// Seconds and 100 nanosecond intervals of FILETIMEs
+const (
+	filetimeEpoch  = 11644473600 // seconds from January 1, 1601 to the Unix epoch
+	filetimeSecond = 10000000
+)
+
+// date returns the time of FILETIME ft, the inverse of filetime.
+// A zero FILETIME, a time never set, is the zero time.Time.
+func date(ft uint64) time.Time {
+	if ft == 0 {
+		return time.Time{}
+	}
+	secs := int64(ft/filetimeSecond) - filetimeEpoch
+	return time.Unix(secs, int64(ft%filetimeSecond)*100).UTC()
+}
+
+// filetime returns t as a FILETIME: the number of 100 nanosecond
+// intervals since January 1, 1601 UTC. The zero time.Time and times
+// before 1601 are the zero FILETIME, times after the last FILETIME
+// the last one.
+func filetime(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	secs := t.Unix() + filetimeEpoch
+	switch {
+	case secs < 0:
+		return 0
+	case uint64(secs) > math.MaxUint64/filetimeSecond:
+		return math.MaxUint64
+	}
+	ft := uint64(secs)*filetimeSecond + uint64(t.Nanosecond()/100)
+	if ft < uint64(secs)*filetimeSecond {
+		return math.MaxUint64
+	}
+	return ft
 }
 
 func stringFromBytes(u []byte) string {
+	if len(u) < 2 {
+		return ""
+	}
 	b := make([]uint16, len(u)/2)
-	for i := 0; i < len(u); i += 2 {
+	for i := 0; i+1 < len(u); i += 2 {
 		b[i/2] = (uint16(u[i+1]) << 8) + uint16(u[i])
 	}
 	if b[len(u)/2-1] == 0 {
@@ -23,9 +61,23 @@ func stringFromBytes(u []byte) string {
 	return string(utf16.Decode(b))
 }
 
+// decodeName decodes a key or value name. Compressed names are stored
+// one byte per character (Latin-1), the others as UTF-16LE.
+// Names with an odd size can not be UTF-16 and are read as compressed.
+func decodeName(b []byte, compressed bool) string {
+	if !compressed && len(b)%2 == 0 {
+		return stringFromBytes(append(b, 0, 0))
+	}
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
 func stringsFromBytes(u []byte) (r []string) {
 	str := make([]uint16, 0)
-	for i := 0; i < len(u); i += 2 {
+	for i := 0; i+1 < len(u); i += 2 {
 		c := (uint16(u[i+1]) << 8) + uint16(u[i]) // utf16-LE to rune
 		if c == 0 && len(str) > 0 {               // end of string
 			r = append(r, string(utf16.Decode(str)))
@@ -94,11 +146,48 @@ func dataSizeFromType(u uint32) int {
 	}
 }
 
+// lhSubKeyHash returns the "lh" hash of a key name,
+// calculated over the upper case UTF-16 characters of the name
 func lhSubKeyHash(str string) uint32 {
 	var hashValue uint32 = 0
-	for idx := 0; idx < len(str); idx++ {
+	for _, c := range utf16.Encode([]rune(str)) {
 		hashValue *= 37
-		hashValue += uint32(unicode.ToUpper(rune(str[idx])))
+		hashValue += uint32(unicode.ToUpper(rune(c)))
 	}
 	return hashValue
 }
+
+// lfSubKeyHash returns the "lf" hint: the first 4 characters of the name
+func lfSubKeyHash(str string) uint32 {
+	b := make([]byte, 4)
+	copy(b, str)
+	return binary.LittleEndian.Uint32(b)
+}
+
+// encodeName encodes a key or value name. Names with characters
+// up to U+00FF are compressed to one byte per character, the others
+// are stored as UTF-16LE
+func encodeName(name string) (b []byte, compressed bool) {
+	for _, r := range name {
+		if r > 0xff {
+			return utf16LEFromString(name), false
+		}
+	}
+	b = make([]byte, 0, len(name))
+	for _, r := range name {
+		b = append(b, byte(r))
+	}
+	return b, true
+}
+
+// utf16Size returns the size of s encoded as UTF-16
+func utf16Size(s string) uint32 {
+	n := uint32(0)
+	for _, r := range s {
+		n += 2
+		if r >= 0x10000 {
+			n += 2
+		}
+	}
+	return n
+}
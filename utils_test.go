@@ -0,0 +1,37 @@
+package registry
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFiletime(t *testing.T) {
+	tests := []struct {
+		ft   uint64
+		want time.Time
+	}{
+		{0, time.Time{}},
+		{1, time.Date(1601, 1, 1, 0, 0, 0, 100, time.UTC)},
+		{116444736000000000, time.Unix(0, 0).UTC()},
+		{132000000000000001, time.Date(2019, 4, 17, 18, 40, 0, 100, time.UTC)},
+		{math.MaxInt64, time.Date(30828, 9, 14, 2, 48, 5, 477580700, time.UTC)},
+		{math.MaxUint64, time.Date(60056, 5, 28, 5, 36, 10, 955161500, time.UTC)},
+	}
+	for _, tt := range tests {
+		got := date(tt.ft)
+		if !got.Equal(tt.want) {
+			t.Errorf("date(%v) = %v, want %v", tt.ft, got, tt.want)
+		}
+		if ft := filetime(got); ft != tt.ft {
+			t.Errorf("filetime(date(%v)) = %v", tt.ft, ft)
+		}
+	}
+
+	if ft := filetime(time.Date(1600, 12, 31, 0, 0, 0, 0, time.UTC)); ft != 0 {
+		t.Errorf("filetime() before 1601 = %v, want 0", ft)
+	}
+	if ft := filetime(time.Date(70000, 1, 1, 0, 0, 0, 0, time.UTC)); ft != math.MaxUint64 {
+		t.Errorf("filetime() after the last FILETIME = %v, want %v", ft, uint64(math.MaxUint64))
+	}
+}
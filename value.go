@@ -0,0 +1,54 @@
+package registry
+
+// Value is a value of a key, as returned by Key.ReadValues
+type Value struct {
+	// Name of the value. The default value is named "(default)", like
+	// in ValueDiff, while RegValue leaves its name empty. The Get and Set
+	// methods of Key accept both.
+	Name string
+	// Type of the value, one of the REG_* constants
+	Type uint32
+
+	raw  []byte
+	data interface{}
+}
+
+func newValue(vk *valueKey) Value {
+	return Value{
+		Name: vk.name,
+		Type: vk.dataType,
+		raw:  vk.raw,
+		data: vk.data,
+	}
+}
+
+// Bytes returns the data of v as stored in the registry
+func (v Value) Bytes() []byte {
+	return v.raw
+}
+
+// String returns the data of a REG_SZ, REG_EXPAND_SZ or REG_LINK value.
+// For other types it returns an empty string.
+func (v Value) String() string {
+	s, _ := v.data.(string)
+	return s
+}
+
+// Strings returns the data of a REG_MULTI_SZ value.
+// For other types it returns nil.
+func (v Value) Strings() []string {
+	s, _ := v.data.([]string)
+	return s
+}
+
+// Uint64 returns the data of a REG_DWORD, REG_DWORD_BIG_ENDIAN or
+// REG_QWORD value. For other types it returns 0.
+func (v Value) Uint64() uint64 {
+	switch d := v.data.(type) {
+	case uint64:
+		return d
+	case uint32:
+		return uint64(d)
+	}
+	return 0
+}
@@ -1,12 +1,104 @@
 package registry
 
+import (
+	"encoding/binary"
+	"io"
+)
+
+// bigDataMaxSegment is the maximum amount of value data stored in a
+// single cell. Bigger values are split in segments referenced by a "db" record.
+const bigDataMaxSegment = 16344
+
 type valueData struct {
+	rws io.ReadWriteSeeker
+
+	binOffset int64
+	offset    uint32
+
 	signature string // must be "db"
 
 	numberSegments uint16
-	dbOffset       uint16 // Data block (segment) list offset. The offset value is in bytes and relative from the start of the hive bin data.
+	dbOffset       uint32 // Data block (segment) list offset. The offset value is in bytes and relative from the start of the hive bin data.
+
+	segments dataBlockSegmentList
+}
+
+func newValueData(rws io.ReadWriteSeeker, binOffset int64, offset uint32) *valueData {
+	return &valueData{
+		rws:       rws,
+		binOffset: binOffset,
+		offset:    offset,
+	}
+}
+
+// Read reads the "db" record and its segment list
+func (vd *valueData) Read() error {
+	r := vd.rws
+
+	_, err := r.Seek(vd.binOffset+int64(vd.offset), io.SeekStart)
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "valueData.Read() r.Seek"}
+	}
+
+	b := make([]byte, 8)
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "valueData.Read() io.ReadFull"}
+	}
+	vd.signature = string(b[:2])
+	vd.numberSegments = binary.LittleEndian.Uint16(b[2:4])
+	vd.dbOffset = binary.LittleEndian.Uint32(b[4:8])
+
+	err = vd.validate()
+	if err != nil {
+		return err
+	}
 
-	padding uint16 // due to 8 byte alignment of cell size. Sometimes contains remnant data
+	_, err = r.Seek(vd.binOffset+int64(vd.dbOffset), io.SeekStart)
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "valueData.Read() r.Seek"}
+	}
+
+	b = make([]byte, 4*int(vd.numberSegments))
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return errorW{err: ErrCorruptRegistry, cause: err, function: "valueData.Read() io.ReadFull"}
+	}
+	vd.segments.entries = make([]uint32, vd.numberSegments)
+	for i := range vd.segments.entries {
+		vd.segments.entries[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+	return nil
+}
+
+// data reads and joins the segments until size bytes are read
+func (vd *valueData) data(size uint32) ([]byte, error) {
+	r := vd.rws
+	b := make([]byte, 0, size)
+	for _, off := range vd.segments.entries {
+		n := size - uint32(len(b))
+		if n == 0 {
+			break
+		}
+		if n > bigDataMaxSegment {
+			n = bigDataMaxSegment
+		}
+
+		_, err := r.Seek(vd.binOffset+int64(off), io.SeekStart)
+		if err != nil {
+			return nil, errorW{err: ErrCorruptRegistry, cause: err, function: "valueData.data() r.Seek"}
+		}
+		seg := make([]byte, n)
+		_, err = io.ReadFull(r, seg)
+		if err != nil {
+			return nil, errorW{err: ErrCorruptRegistry, cause: err, function: "valueData.data() io.ReadFull"}
+		}
+		b = append(b, seg...)
+	}
+	if uint32(len(b)) != size {
+		return nil, errorW{err: ErrCorruptRegistry, cause: errShortDataBlock, function: "valueData.data()"}
+	}
+	return b, nil
 }
 
 func (vd valueData) validate() error {
@@ -18,5 +110,5 @@ func (vd valueData) validate() error {
 }
 
 type dataBlockSegmentList struct {
-	entries []uint16
+	entries []uint32
 }
@@ -2,7 +2,6 @@ package registry
 
 import (
 	"encoding/binary"
-	"fmt"
 	"io"
 )
 
@@ -32,6 +31,8 @@ type valueKey struct {
 	name string
 
 	data interface{}
+
+	raw []byte // data as stored in the hive, before decoding
 }
 
 func newValueKey(rws io.ReadWriteSeeker, binOffset int64, valueOffset uint32) *valueKey {
@@ -73,7 +74,7 @@ func (vk *valueKey) Read() error {
 		if err != nil {
 			return errorW{err: ErrCorruptRegistry, cause: err, function: "valueKey.Read() io.ReadFull"}
 		}
-		vk.name = string(b)
+		vk.name = decodeName(b, vk.flags&vk_VALUE_COMP_NAME != 0)
 	}
 
 	// If the MSB of the data size is set the data offset actually contains the data value.
@@ -82,7 +83,7 @@ func (vk *valueKey) Read() error {
 
 		switch dataSize[0] {
 		case 0:
-			vk.data = nil
+			vk.data = []byte{}
 			vk.dataSize = 0
 		case 1:
 			vk.data = b[3:]
@@ -94,35 +95,33 @@ func (vk *valueKey) Read() error {
 			vk.data = b[:]
 			vk.dataSize = 4
 		}
-	} else {
-		_, err = r.Seek(vk.binOffset+int64(vk.dataOffset), io.SeekStart)
-		if err != nil {
-			return errorW{err: ErrCorruptRegistry, cause: err, function: "valueKey.Read() r.Seek"}
-		}
-		b = make([]byte, vk.dataSize)
-		_, err := io.ReadFull(r, b)
+	} else if vk.dataSize > 0 {
+		b, err = readCellData(r, vk.binOffset, vk.dataOffset, vk.dataSize)
 		if err != nil {
-			return errorW{err: ErrCorruptRegistry, cause: err, function: "valueKey.Read() io.ReadFull"}
+			return err
 		}
 		vk.data = b[:]
+	} else {
+		vk.data = []byte{}
 	}
 
-	if vk.data != nil {
-		switch vk.dataType {
-		case REG_BINARY: // already []byte
-		case REG_SZ, REG_EXPAND_SZ:
-			vk.data = stringFromBytes(vk.data.([]byte))
+	vk.raw = vk.data.([]byte)
+	switch vk.dataType {
+	case REG_SZ, REG_EXPAND_SZ, REG_LINK:
+		vk.data = stringFromBytes(vk.raw)
+		if vk.dataSize > 0 {
 			vk.dataSize = (vk.dataSize - 1) / 2 // 2 byte char to 1 byte char excluding \0
-		case REG_DWORD, REG_QWORD:
-			vk.data = uint64FromBytesLE(vk.data.([]byte))
-		case REG_DWORD_BIG_ENDIAN:
-			vk.data = uint32FromBytesBE(vk.data.([]byte))
-		case REG_MULTI_SZ:
-			vk.data = stringsFromBytes(vk.data.([]byte))
+		}
+	case REG_DWORD, REG_QWORD:
+		vk.data = uint64FromBytesLE(vk.raw)
+	case REG_DWORD_BIG_ENDIAN:
+		vk.data = uint32FromBytesBE(vk.raw)
+	case REG_MULTI_SZ:
+		vk.data = stringsFromBytes(vk.raw)
+		if vk.dataSize > 0 {
 			vk.dataSize = (vk.dataSize - 1) / 2 // 2 byte char to 1 byte char excluding \0
-		default:
-			return fmt.Errorf("Data type %v not supported yet", Type(vk.dataType))
 		}
+	default: // REG_BINARY, REG_NONE and resource lists are kept as []byte
 	}
 
 	return vk.validate()
@@ -135,3 +134,40 @@ func (vk *valueKey) validate() error {
 
 	return nil
 }
+
+// readCellData reads size bytes of value data stored at offset.
+// Data bigger than bigDataMaxSegment may be stored in a "db" record,
+// in which case the segments are read and concatenated.
+func readCellData(r io.ReadWriteSeeker, binOffset int64, offset, size uint32) ([]byte, error) {
+	_, err := r.Seek(binOffset+int64(offset), io.SeekStart)
+	if err != nil {
+		return nil, errorW{err: ErrCorruptRegistry, cause: err, function: "readCellData r.Seek"}
+	}
+
+	if size > bigDataMaxSegment {
+		sig := make([]byte, 2)
+		_, err = io.ReadFull(r, sig)
+		if err != nil {
+			return nil, errorW{err: ErrCorruptRegistry, cause: err, function: "readCellData io.ReadFull"}
+		}
+		if string(sig) == dataBlockSig {
+			vd := newValueData(r, binOffset, offset)
+			err = vd.Read()
+			if err != nil {
+				return nil, err
+			}
+			return vd.data(size)
+		}
+		_, err = r.Seek(-2, io.SeekCurrent)
+		if err != nil {
+			return nil, errorW{err: ErrCorruptRegistry, cause: err, function: "readCellData r.Seek"}
+		}
+	}
+
+	b := make([]byte, size)
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return nil, errorW{err: ErrCorruptRegistry, cause: err, function: "readCellData io.ReadFull"}
+	}
+	return b, nil
+}
@@ -2,6 +2,7 @@ package registry
 
 import (
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -32,7 +33,8 @@ func Test_valueKey_Read(t *testing.T) {
 			}
 
 			vk.rws = nil
-			if *vk != tt.want {
+			vk.raw = nil
+			if !reflect.DeepEqual(*vk, tt.want) {
 				t.Errorf("Read error:\nvk      = %+v;\ntt.want = %+v", *vk, tt.want)
 			}
 		})
@@ -0,0 +1,497 @@
+package registry
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	wineHeader     = "WINE REGISTRY Version 2"
+	wineBaseprefix = ";; All keys relative to "
+
+	// maxWineHexChars is the column after which Wine wraps hex data
+	maxWineHexChars = 76
+
+	// wineEscapes are the C escapes of the control characters, '.' if none
+	wineEscapes = ".......abtnvfr.............e...."
+)
+
+// WineFile is the content of a Wine registry file: system.reg,
+// user.reg or userdef.reg
+type WineFile struct {
+	// Base is the path the keys are relative to,
+	// like \Machine or \User\S-1-5-21-0-0-0-1000
+	Base string
+	// Arch is the architecture of the Wine prefix: win32, win64 or empty
+	Arch string
+
+	Keys []WineKey
+}
+
+// WineKey is a key section of a Wine registry file
+type WineKey struct {
+	RegKey
+	Class        string
+	LastModified time.Time
+}
+
+// WineOptions are the options of Key.ExportWine
+type WineOptions struct {
+	// Base is written in the header, see WineFile
+	Base string
+	// Arch is written in the header, see WineFile
+	Arch string
+
+	// Root is the path of the root key of the registry relative to Base,
+	// like Software for a SOFTWARE hive in system.reg. Empty for user.reg.
+	Root string
+}
+
+// ExportWine writes key k, its values and subkeys to w in the format of
+// the Wine registry files, keeping the last write times, class names and
+// value types. As Wine does, keys without values but with subkeys
+// are only written as part of the path of their subkeys.
+func (k Key) ExportWine(w io.Writer, opts *WineOptions) error {
+	if opts == nil {
+		opts = &WineOptions{}
+	}
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "%s\n%s%s\n", wineHeader, wineBaseprefix, wineEscapePath(opts.Base))
+	if opts.Arch != "" {
+		fmt.Fprintf(bw, "\n#arch=%s\n", opts.Arch)
+	}
+
+	path, err := k.Path()
+	if err != nil {
+		return err
+	}
+	root := strings.Trim(opts.Root, string(separator))
+	if root != "" && path != "" {
+		path = root + string(separator) + path
+	} else if root != "" {
+		path = root
+	}
+
+	err = exportWineKey(bw, k, path)
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// exportWineKey writes key k, known as path, and its subkeys
+func exportWineKey(w *bufio.Writer, k Key, path string) error {
+	k = newKey(k.registry, k.rws, k.nk) // keep the cursor of k
+	nk := k.nk
+
+	if nk.numberOfValues > 0 || nk.numberOfSubKeys == 0 || nk.classNameSize > 0 || nk.flags&nk_KEY_SYM_LINK != 0 {
+		ft := int64(nk.lastModified)
+		fmt.Fprintf(w, "\n[%s] %d\n", wineEscapePath(path), uint32((ft-116444736000000000)/10000000))
+		fmt.Fprintf(w, "#time=%x\n", nk.lastModified)
+
+		if nk.classNameSize > 0 && nk.classNameOffset != noOffset {
+			class, err := readCellData(k.rws, nk.binOffset, nk.classNameOffset, uint32(nk.classNameSize))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "#class=\"%s\"\n", wineEscape(utf16FromBytes(class), `"`))
+		}
+		if nk.flags&nk_KEY_SYM_LINK != 0 {
+			w.WriteString("#link\n")
+		}
+
+		values, err := k.ReadValues(-1)
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			exportWineValue(w, v)
+		}
+	}
+
+	names, err := k.ReadSubKeyNames(-1)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(names, func(i, j int) bool { return compareKeyNames(names[i], names[j]) < 0 })
+	for _, name := range names {
+		sub, err := k.OpenSubKey(name)
+		if err != nil {
+			return err
+		}
+		p := name
+		if path != "" {
+			p = path + string(separator) + name
+		}
+		err = exportWineKey(w, sub, p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportWineValue writes the line of value v
+func exportWineValue(w *bufio.Writer, v Value) {
+	name := "@="
+	if v.Name != defaultValueName {
+		name = `"` + wineEscape(utf16.Encode([]rune(v.Name)), `"`) + `"=`
+	}
+	w.WriteString(name)
+
+	data := v.raw
+	switch v.Type {
+	case REG_SZ, REG_EXPAND_SZ, REG_MULTI_SZ:
+		// only strings terminated by a NUL character
+		if len(data) < 2 || len(data)%2 != 0 || data[len(data)-2] != 0 || data[len(data)-1] != 0 {
+			break
+		}
+		if v.Type != REG_SZ {
+			fmt.Fprintf(w, "str(%x):", v.Type)
+		}
+		u := utf16FromBytes(data)
+		fmt.Fprintf(w, "\"%s\"\n", wineEscape(u[:len(u)-1], `"`))
+		return
+	case REG_DWORD:
+		if len(data) == 4 {
+			fmt.Fprintf(w, "dword:%08x\n", binary.LittleEndian.Uint32(data))
+			return
+		}
+	}
+	w.WriteString(wineHexData(len(name), v.Type, data) + "\n")
+}
+
+// wineHexData formats data as "hex:" or "hex(type):" followed by the bytes,
+// wrapping the lines as Wine does. column is the length of the line
+// before the data.
+func wineHexData(column int, valtype uint32, data []byte) string {
+	var sb strings.Builder
+	if valtype == REG_BINARY {
+		sb.WriteString("hex:")
+	} else {
+		fmt.Fprintf(&sb, "hex(%x):", valtype)
+	}
+	column += sb.Len()
+
+	for i, b := range data {
+		fmt.Fprintf(&sb, "%02x", b)
+		column += 2
+		if i == len(data)-1 {
+			break
+		}
+		sb.WriteByte(',')
+		column++
+		if column > maxWineHexChars {
+			sb.WriteString("\\\n  ")
+			column = 2
+		}
+	}
+	return sb.String()
+}
+
+// wineEscape escapes UTF-16 string u as Wine does: backslashes and the
+// characters of special, control characters as C escapes and non ASCII
+// characters as \x escapes
+func wineEscape(u []uint16, special string) string {
+	var sb strings.Builder
+	for i, c := range u {
+		next := -1
+		if i+1 < len(u) {
+			next = int(u[i+1])
+		}
+		switch {
+		case c > 127:
+			if next >= 0 && next < 128 && strings.ContainsRune("0123456789abcdefABCDEF", rune(next)) {
+				fmt.Fprintf(&sb, "\\x%04x", c)
+			} else {
+				fmt.Fprintf(&sb, "\\x%x", c)
+			}
+		case c < 32:
+			if wineEscapes[c] != '.' {
+				sb.WriteByte('\\')
+				sb.WriteByte(wineEscapes[c])
+			} else if next >= '0' && next <= '7' {
+				fmt.Fprintf(&sb, "\\%03o", c)
+			} else {
+				fmt.Fprintf(&sb, "\\%o", c)
+			}
+		default:
+			if c == '\\' || strings.ContainsRune(special, rune(c)) {
+				sb.WriteByte('\\')
+			}
+			sb.WriteByte(byte(c))
+		}
+	}
+	return sb.String()
+}
+
+// wineEscapePath escapes the names of path, separated by double backslashes
+func wineEscapePath(path string) string {
+	names := strings.Split(path, string(separator))
+	for i, name := range names {
+		names[i] = wineEscape(utf16.Encode([]rune(name)), "[]")
+	}
+	return strings.Join(names, `\\`)
+}
+
+// utf16FromBytes returns the UTF-16 characters of little endian data b
+func utf16FromBytes(b []byte) []uint16 {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return u
+}
+
+// ParseWine parses a Wine registry file
+func ParseWine(rd io.Reader) (*WineFile, error) {
+	b, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(b), "\n")
+
+	f := &WineFile{}
+	header := false
+	var key *WineKey
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(strings.TrimSuffix(lines[i], "\r"))
+		errorf := func(format string, a ...interface{}) error {
+			return errorW{
+				err:      ErrBadRegistry,
+				cause:    fmt.Errorf("line %d: "+format, append([]interface{}{i + 1}, a...)...),
+				function: "ParseWine",
+			}
+		}
+
+		switch {
+		case line == "":
+
+		case !header:
+			if line != wineHeader {
+				return nil, errorW{err: ErrBadRegistry, cause: errWineHeader, function: "ParseWine"}
+			}
+			header = true
+
+		case strings.HasPrefix(line, wineBaseprefix):
+			f.Base = wineUnescapePath(line[len(wineBaseprefix):])
+
+		case strings.HasPrefix(line, "#arch="):
+			f.Arch = line[len("#arch="):]
+
+		case strings.HasPrefix(line, "["):
+			u, rest, ok := wineUnescape(line[1:], ']')
+			if !ok {
+				return nil, errorf("unterminated key %q", line)
+			}
+			f.Keys = append(f.Keys, WineKey{RegKey: RegKey{Path: string(utf16.Decode(u))}})
+			key = &f.Keys[len(f.Keys)-1]
+			if s := strings.TrimSpace(rest); s != "" {
+				secs, err := strconv.ParseUint(s, 10, 32)
+				if err != nil {
+					return nil, errorf("invalid time %q", s)
+				}
+				key.LastModified = time.Unix(int64(secs), 0).UTC()
+			}
+
+		case strings.HasPrefix(line, ";"):
+
+		case key == nil:
+			return nil, errorf("value outside of a key")
+
+		case strings.HasPrefix(line, "#time="):
+			ft, err := strconv.ParseUint(line[len("#time="):], 16, 64)
+			if err != nil {
+				return nil, errorf("invalid time %q", line)
+			}
+			key.LastModified = date(ft)
+
+		case strings.HasPrefix(line, "#class="):
+			class, rest, ok := wineUnescapeQuoted(line[len("#class="):])
+			if !ok || strings.TrimSpace(rest) != "" {
+				return nil, errorf("invalid class %q", line)
+			}
+			key.Class = string(utf16.Decode(class))
+
+		case strings.HasPrefix(line, "#"):
+			// #link and unknown options
+
+		default:
+			// hex data continues on the next lines
+			for strings.HasSuffix(line, `\`) && i+1 < len(lines) {
+				i++
+				line = strings.TrimSuffix(line, `\`) + strings.TrimSpace(strings.TrimSuffix(lines[i], "\r"))
+			}
+			v, err := parseWineValue(line)
+			if err != nil {
+				return nil, errorf("%v", err)
+			}
+			key.Values = append(key.Values, v)
+		}
+	}
+	if !header {
+		return nil, errorW{err: ErrBadRegistry, cause: errWineHeader, function: "ParseWine"}
+	}
+	return f, nil
+}
+
+// parseWineValue parses a "name"=data line
+func parseWineValue(line string) (RegValue, error) {
+	var v RegValue
+	data := ""
+	if strings.HasPrefix(line, "@") {
+		data = line[1:]
+	} else {
+		name, rest, ok := wineUnescapeQuoted(line)
+		if !ok {
+			return v, fmt.Errorf("invalid value name in %q", line)
+		}
+		v.Name, data = string(utf16.Decode(name)), rest
+	}
+	data = strings.TrimSpace(data)
+	if !strings.HasPrefix(data, "=") {
+		return v, fmt.Errorf("missing '=' in %q", line)
+	}
+	data = strings.TrimSpace(data[1:])
+
+	switch {
+	case strings.HasPrefix(data, `"`), strings.HasPrefix(data, "str("):
+		v.Type = REG_SZ
+		if strings.HasPrefix(data, "str(") {
+			end := strings.Index(data, "):")
+			if end < 0 {
+				return v, fmt.Errorf("invalid string type %q", data)
+			}
+			n, err := strconv.ParseUint(data[len("str("):end], 16, 32)
+			if err != nil {
+				return v, fmt.Errorf("invalid string type %q", data)
+			}
+			v.Type, data = uint32(n), data[end+2:]
+		}
+		s, rest, ok := wineUnescapeQuoted(data)
+		if !ok || strings.TrimSpace(rest) != "" {
+			return v, fmt.Errorf("invalid string %q", data)
+		}
+		v.Data = make([]byte, 2*len(s)+2)
+		for i, c := range s {
+			binary.LittleEndian.PutUint16(v.Data[2*i:], c)
+		}
+
+	case strings.HasPrefix(data, "dword:"):
+		n, err := strconv.ParseUint(data[len("dword:"):], 16, 32)
+		if err != nil {
+			return v, fmt.Errorf("invalid dword %q", data)
+		}
+		v.Type = REG_DWORD
+		v.Data = make([]byte, 4)
+		binary.LittleEndian.PutUint32(v.Data, uint32(n))
+
+	case strings.HasPrefix(data, "hex"):
+		v.Type = REG_BINARY
+		data = data[len("hex"):]
+		if strings.HasPrefix(data, "(") {
+			end := strings.IndexByte(data, ')')
+			if end < 0 {
+				return v, fmt.Errorf("invalid hex type %q", data)
+			}
+			n, err := strconv.ParseUint(data[1:end], 16, 32)
+			if err != nil {
+				return v, fmt.Errorf("invalid hex type %q", data)
+			}
+			v.Type, data = uint32(n), data[end+1:]
+		}
+		if !strings.HasPrefix(data, ":") {
+			return v, fmt.Errorf("invalid hex data %q", data)
+		}
+		var err error
+		v.Data, err = parseRegHex(data[1:])
+		if err != nil {
+			return v, fmt.Errorf("invalid hex data %q", data)
+		}
+
+	default:
+		return v, fmt.Errorf("invalid value data %q", data)
+	}
+	return v, nil
+}
+
+// wineUnescapeQuoted unescapes the quoted string at the start of s,
+// returning the rest of s
+func wineUnescapeQuoted(s string) ([]uint16, string, bool) {
+	if !strings.HasPrefix(s, `"`) {
+		return nil, "", false
+	}
+	return wineUnescape(s[1:], '"')
+}
+
+// wineUnescape unescapes s up to the character end, returning
+// the rest of s after end
+func wineUnescape(s string, end rune) ([]uint16, string, bool) {
+	u := []uint16{}
+	r := []rune(s)
+	for i := 0; i < len(r); i++ {
+		c := r[i]
+		if c == end {
+			return u, string(r[i+1:]), true
+		}
+		if c != '\\' || i+1 == len(r) {
+			u = append(u, utf16.Encode([]rune{c})...)
+			continue
+		}
+
+		i++
+		c = r[i]
+		switch {
+		case c == 'x':
+			n := 0
+			for ; n < 4 && i+1+n < len(r) && isHexDigit(r[i+1+n]); n++ {
+			}
+			x, _ := strconv.ParseUint(string(r[i+1:i+1+n]), 16, 16)
+			u = append(u, uint16(x))
+			i += n
+		case c >= '0' && c <= '7':
+			n := 1
+			for ; n < 3 && i+n < len(r) && r[i+n] >= '0' && r[i+n] <= '7'; n++ {
+			}
+			o, _ := strconv.ParseUint(string(r[i:i+n]), 8, 16)
+			u = append(u, uint16(o))
+			i += n - 1
+		case c < 128 && c != '.' && strings.ContainsRune(wineEscapes, c):
+			u = append(u, uint16(strings.IndexRune(wineEscapes, c)))
+		default:
+			u = append(u, utf16.Encode([]rune{c})...)
+		}
+	}
+	return nil, "", false
+}
+
+// wineUnescapePath turns the double backslashes of path into single ones
+func wineUnescapePath(path string) string {
+	return strings.Replace(path, `\\`, string(separator), -1)
+}
+
+func isHexDigit(c rune) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// ImportWine applies the keys and values of f to registry r, keeping
+// their last write times and class names. root is the path of the root
+// key of r in f, like Software for a SOFTWARE hive and system.reg; keys
+// outside of root are ignored. If the import fails, the registry is
+// left unchanged.
+func (r Registry) ImportWine(f *WineFile, root string) error {
+	keys := make([]importRecord, len(f.Keys))
+	for i, key := range f.Keys {
+		keys[i] = importRecord{RegKey: key.RegKey, class: key.Class, lastModified: key.LastModified}
+	}
+	return r.importKeys(keys, root)
+}
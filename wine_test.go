@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKey_ExportWine(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	root, err := r.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, _, err := root.CreateKey(`Wine\[Sub]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.setClassName(`Cl"ass`); err != nil {
+		t.Fatal(err)
+	}
+	k, _ = k.Parent()
+	for _, set := range []error{
+		k.SetStringValue("", "default"),
+		k.SetStringValue(`Quote"Back\slash`, "tab\t\x01é€1"),
+		k.SetDWordValue("DWord", 0x12ab),
+		k.SetBinaryValue("Binary", bytes.Repeat([]byte{0xab}, 30)),
+		k.SetStringsValue("Multi", []string{"a", "7"}),
+		k.SetExpandStringValue("Expand", "%x%"),
+		k.setValue("Unterminated", REG_SZ, []byte{'a', 0}),
+	} {
+		if set != nil {
+			t.Fatal(set)
+		}
+	}
+	ft := filetime(time.Date(2020, 5, 4, 17, 12, 25, 123456700, time.UTC))
+	sub, err := k.OpenSubKey("[Sub]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []Key{k, sub} {
+		key.nk.lastModified = ft
+		if err := key.nk.Write(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := "WINE REGISTRY Version 2\n" +
+		";; All keys relative to \\\\User\\\\S-1-5-21-0-0-0-1000\n" +
+		"\n#arch=win64\n" +
+		"\n[Software\\\\Wine] 1588612345\n" +
+		"#time=1d622372e69b907\n" +
+		"@=\"default\"\n" +
+		"\"Quote\\\"Back\\\\slash\"=\"tab\\t\\1\\xe9\\x20ac1\"\n" +
+		"\"DWord\"=dword:000012ab\n" +
+		"\"Binary\"=hex:ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,ab,\\\n" +
+		"  ab,ab,ab,ab,ab,ab,ab,ab\n" +
+		"\"Multi\"=str(7):\"a\\0007\\0\"\n" +
+		"\"Expand\"=str(2):\"%x%\"\n" +
+		"\"Unterminated\"=hex(1):61,00\n" +
+		"\n[Software\\\\Wine\\\\\\[Sub\\]] 1588612345\n" +
+		"#time=1d622372e69b907\n" +
+		"#class=\"Cl\\\"ass\"\n"
+
+	var buf bytes.Buffer
+	opts := &WineOptions{Base: `\User\S-1-5-21-0-0-0-1000`, Arch: "win64", Root: "Software"}
+	if err := k.ExportWine(&buf, opts); err != nil {
+		t.Fatalf("Key.ExportWine() error = %v", err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("Key.ExportWine() = %q, want %q", got, want)
+	}
+
+	f, err := ParseWine(&buf)
+	if err != nil {
+		t.Fatalf("ParseWine() error = %v", err)
+	}
+	if f.Base != opts.Base || f.Arch != opts.Arch || len(f.Keys) != 2 {
+		t.Fatalf("ParseWine() = %+v", f)
+	}
+	if key := f.Keys[1]; key.Path != `Software\Wine\[Sub]` || key.Class != `Cl"ass` || filetime(key.LastModified) != ft {
+		t.Errorf("ParseWine() key = %+v", key)
+	}
+	if err := r.ImportWine(f, ""); err != nil {
+		t.Fatalf("Registry.ImportWine() error = %v", err)
+	}
+	imported, err := r.OpenKey(`Software\Wine\[Sub]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.nk.classNameSize != 12 || imported.nk.lastModified != ft {
+		t.Errorf("imported key class size = %v, time = %x", imported.nk.classNameSize, imported.nk.lastModified)
+	}
+}
+
+func TestRegistry_ImportWine(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	src, err := Open("testdata/NTUSER.DAT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	root, err := src.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := root.ExportWine(&buf, &WineOptions{Root: "Users"}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ParseWine(&buf)
+	if err != nil {
+		t.Fatalf("ParseWine() error = %v", err)
+	}
+
+	d, err := Create(filepath.Join(filepath.Dir(file), "wine.dat"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.ImportWine(f, "users"); err != nil {
+		t.Fatalf("Registry.ImportWine() error = %v", err)
+	}
+	if got, want := testTree(t, d), testTree(t, src); !reflect.DeepEqual(got, want) {
+		t.Errorf("imported registry has %v keys, want %v", len(got), len(want))
+	}
+
+	// keys written in the file keep their last write time
+	for _, key := range f.Keys {
+		path := strings.TrimPrefix(strings.TrimPrefix(key.Path, "Users"), `\`)
+		s, err := src.OpenKey(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := d.OpenKey(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k.nk.lastModified != s.nk.lastModified {
+			t.Errorf("last write time of %v = %x, want %x", path, k.nk.lastModified, s.nk.lastModified)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package registry
+
+import "strings"
+
+// Access rights of golang's sys/windows/registry module. OpenKey and
+// OpenSubKey accept them and ignore all but WOW64_32KEY and WOW64_64KEY.
+const (
+	ALL_ACCESS         = 0xf003f
+	CREATE_LINK        = 0x00020
+	CREATE_SUB_KEY     = 0x00004
+	ENUMERATE_SUB_KEYS = 0x00008
+	EXECUTE            = 0x20019
+	NOTIFY             = 0x00010
+	QUERY_VALUE        = 0x00001
+	READ               = 0x20019
+	SET_VALUE          = 0x00002
+	WOW64_32KEY        = 0x00200 // the view of 32-bit applications, redirected to WOW6432Node
+	WOW64_64KEY        = 0x00100 // the view of 64-bit applications, the keys as stored
+	WRITE              = 0x20006
+)
+
+// wow64Node is the key holding the keys of 32-bit applications
+const wow64Node = "WOW6432Node"
+
+// wow64Classes is the key of SOFTWARE holding the classes, shared by
+// 32 and 64-bit applications except for wow64ClassesRedirected
+const wow64Classes = "Classes"
+
+// wow64ClassesRedirected are the subkeys of Classes redirected to
+// Classes\Wow6432Node
+var wow64ClassesRedirected = []string{
+	"CLSID",
+	"DirectShow",
+	"Interface",
+	"Media Type",
+	"MediaFoundation",
+}
+
+// wow64Shared are the keys of SOFTWARE shared by 32 and 64-bit
+// applications since Windows 7, with their subkeys. The other keys,
+// out of Classes, are redirected to WOW6432Node.
+var wow64Shared = []string{
+	`Clients`,
+	`Microsoft\COM3`,
+	`Microsoft\Cryptography\Calais\Current`,
+	`Microsoft\Cryptography\Calais\Readers`,
+	`Microsoft\Cryptography\Services`,
+	`Microsoft\CTF\SystemShared`,
+	`Microsoft\CTF\TIP`,
+	`Microsoft\DFS`,
+	`Microsoft\Driver Signing`,
+	`Microsoft\EnterpriseCertificates`,
+	`Microsoft\EventSystem`,
+	`Microsoft\MSMQ`,
+	`Microsoft\Non-Driver Signing`,
+	`Microsoft\Notepad\DefaultFonts`,
+	`Microsoft\OLE`,
+	`Microsoft\RAS`,
+	`Microsoft\RPC`,
+	`Microsoft\Shared Tools\MSInfo`,
+	`Microsoft\SystemCertificates`,
+	`Microsoft\TermServLicensing`,
+	`Microsoft\Transaction Server`,
+	`Microsoft\Windows\CurrentVersion\App Paths`,
+	`Microsoft\Windows\CurrentVersion\Control Panel\Cursors\Schemes`,
+	`Microsoft\Windows\CurrentVersion\Explorer\AutoplayHandlers`,
+	`Microsoft\Windows\CurrentVersion\Explorer\DriveIcons`,
+	`Microsoft\Windows\CurrentVersion\Explorer\KindMap`,
+	`Microsoft\Windows\CurrentVersion\Group Policy`,
+	`Microsoft\Windows\CurrentVersion\Policies`,
+	`Microsoft\Windows\CurrentVersion\PreviewHandlers`,
+	`Microsoft\Windows\CurrentVersion\Setup`,
+	`Microsoft\Windows\CurrentVersion\Telephony\Locations`,
+	`Microsoft\Windows NT\CurrentVersion\Console`,
+	`Microsoft\Windows NT\CurrentVersion\FontDpi`,
+	`Microsoft\Windows NT\CurrentVersion\FontLink`,
+	`Microsoft\Windows NT\CurrentVersion\FontMapper`,
+	`Microsoft\Windows NT\CurrentVersion\Fonts`,
+	`Microsoft\Windows NT\CurrentVersion\FontSubstitutes`,
+	`Microsoft\Windows NT\CurrentVersion\Gre_Initialize`,
+	`Microsoft\Windows NT\CurrentVersion\Image File Execution Options`,
+	`Microsoft\Windows NT\CurrentVersion\LanguagePack`,
+	`Microsoft\Windows NT\CurrentVersion\NetworkCards`,
+	`Microsoft\Windows NT\CurrentVersion\Perflib`,
+	`Microsoft\Windows NT\CurrentVersion\Ports`,
+	`Microsoft\Windows NT\CurrentVersion\Print`,
+	`Microsoft\Windows NT\CurrentVersion\ProfileList`,
+	`Microsoft\Windows NT\CurrentVersion\Time Zones`,
+	`Policies`,
+	`RegisteredApplications`,
+}
+
+// wow64View returns whether the 32-bit view of r is redirected: hives
+// of 64-bit systems holding classes have a WOW6432Node key. prefix is
+// the path of the root key of r in SOFTWARE: empty for SOFTWARE and
+// Classes for the UsrClass.dat hives of users.
+func (r Registry) wow64View() (prefix string, ok bool) {
+	root := newKey(r, r.rws, r.root)
+	if _, err := root.openSubKey([]string{wow64Node}); err != nil {
+		return "", false
+	}
+	if _, err := root.openSubKey([]string{wow64Classes}); err != nil {
+		return wow64Classes, true
+	}
+	return "", true
+}
+
+// wow64Path returns the key seen by 32-bit applications at path,
+// relative to the root key of SOFTWARE
+func wow64Path(path string) string {
+	names := strings.Split(path, string(separator))
+	if path == "" || strings.EqualFold(names[0], wow64Node) {
+		return path
+	}
+
+	if strings.EqualFold(names[0], wow64Classes) {
+		if len(names) == 1 || strings.EqualFold(names[1], wow64Node) {
+			return path
+		}
+		for _, key := range wow64ClassesRedirected {
+			if strings.EqualFold(names[1], key) {
+				return joinPath(wow64Classes+`\`+wow64Node, strings.Join(names[1:], string(separator)))
+			}
+		}
+		return path
+	}
+
+	for _, key := range wow64Shared {
+		if _, ok := trimRegRoot(path, key); ok {
+			return path
+		}
+	}
+	return wow64Node + `\` + path
+}
+
+// openWow64 opens the subkey path of k seen by 32-bit applications
+func (k Key) openWow64(path string) (Key, error) {
+	r := k.registry
+	prefix, ok := r.wow64View()
+	if !ok || path == "" {
+		return k.openSubKey(strings.Split(path, string(separator)))
+	}
+	kpath, err := k.Path()
+	if err != nil {
+		return Key{}, err
+	}
+	path = joinPath(kpath, path)
+	if prefix != "" {
+		path = joinPath(prefix, path)
+	}
+	path, _ = trimRegRoot(wow64Path(path), prefix)
+
+	root := newKey(r, r.rws, r.root)
+	if path == "" {
+		return root, nil
+	}
+	return root.openSubKey(strings.Split(path, string(separator)))
+}
+
+// wow64Access reports whether access selects the 32-bit view
+func wow64Access(access []uint32) bool {
+	var a uint32
+	for _, f := range access {
+		a |= f
+	}
+	return a&WOW64_32KEY != 0 && a&WOW64_64KEY == 0
+}
@@ -0,0 +1,133 @@
+package registry
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWow64Path(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: ``, want: ``},
+		{path: `Microsoft\Windows\CurrentVersion\Uninstall`, want: `WOW6432Node\Microsoft\Windows\CurrentVersion\Uninstall`},
+		{path: `Vendor`, want: `WOW6432Node\Vendor`},
+		{path: `wow6432node\Vendor`, want: `wow6432node\Vendor`},
+		{path: `Microsoft\Windows\CurrentVersion\App Paths`, want: `Microsoft\Windows\CurrentVersion\App Paths`},
+		{path: `microsoft\windows nt\currentversion\ProfileList\S-1-5-18`, want: `microsoft\windows nt\currentversion\ProfileList\S-1-5-18`},
+		{path: `Microsoft\Windows NT\CurrentVersion\ProfileListX`, want: `WOW6432Node\Microsoft\Windows NT\CurrentVersion\ProfileListX`},
+		{path: `Microsoft\Shared Tools\MSInfo`, want: `Microsoft\Shared Tools\MSInfo`},
+		{path: `Microsoft\Shared Tools`, want: `WOW6432Node\Microsoft\Shared Tools`},
+		{path: `Policies\Microsoft`, want: `Policies\Microsoft`},
+		{path: `Classes`, want: `Classes`},
+		{path: `Classes\.txt`, want: `Classes\.txt`},
+		{path: `Classes\AppID\{A}`, want: `Classes\AppID\{A}`},
+		{path: `Classes\clsid\{A}`, want: `Classes\WOW6432Node\clsid\{A}`},
+		{path: `Classes\Wow6432Node\CLSID`, want: `Classes\Wow6432Node\CLSID`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := wow64Path(tt.path); got != tt.want {
+				t.Errorf("wow64Path() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistry_OpenKeyWow64(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+	dir := filepath.Dir(file)
+
+	software := testHive(t, filepath.Join(dir, "SOFTWARE"), func(k Key) error {
+		return testClasses(k,
+			`Microsoft\Windows\CurrentVersion\Uninstall\App64`,
+			`WOW6432Node\Microsoft\Windows\CurrentVersion\Uninstall\App32`,
+			`Microsoft\Windows\CurrentVersion\App Paths\app.exe`,
+			`Classes\.txt`,
+			`Classes\CLSID\{64}`,
+			`Classes\Wow6432Node\CLSID\{32}`,
+			`Vendor`,
+			`WOW6432Node\Vendor\App`,
+		)
+	})
+	defer software.Close()
+	classes := testHive(t, filepath.Join(dir, "UsrClass.dat"), func(k Key) error {
+		return testClasses(k, `.txt`, `CLSID\{64}`, `Wow6432Node\CLSID\{32}`)
+	})
+	defer classes.Close()
+	ntuser, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ntuser.Close()
+
+	tests := []struct {
+		name     string
+		registry Registry
+		path     string
+		access   []uint32
+		want     string
+		wantErr  error
+	}{
+		{name: "32-bit", registry: software, path: `Microsoft\Windows\CurrentVersion\Uninstall\App32`, access: []uint32{WOW64_32KEY}, want: `WOW6432Node\Microsoft\Windows\CurrentVersion\Uninstall\App32`},
+		{name: "32-bit read", registry: software, path: `Microsoft\Windows\CurrentVersion\Uninstall\App32`, access: []uint32{READ | WOW64_32KEY}, want: `WOW6432Node\Microsoft\Windows\CurrentVersion\Uninstall\App32`},
+		{name: "64-bit key in 32-bit view", registry: software, path: `Microsoft\Windows\CurrentVersion\Uninstall\App64`, access: []uint32{WOW64_32KEY}, wantErr: ErrNotExist},
+		{name: "64-bit", registry: software, path: `Microsoft\Windows\CurrentVersion\Uninstall\App64`, access: []uint32{WOW64_64KEY}, want: `Microsoft\Windows\CurrentVersion\Uninstall\App64`},
+		{name: "default view", registry: software, path: `Microsoft\Windows\CurrentVersion\Uninstall\App64`, access: []uint32{QUERY_VALUE}, want: `Microsoft\Windows\CurrentVersion\Uninstall\App64`},
+		{name: "shared", registry: software, path: `Microsoft\Windows\CurrentVersion\App Paths\app.exe`, access: []uint32{WOW64_32KEY}, want: `Microsoft\Windows\CurrentVersion\App Paths\app.exe`},
+		{name: "shared class", registry: software, path: `Classes\.txt`, access: []uint32{WOW64_32KEY}, want: `Classes\.txt`},
+		{name: "redirected class", registry: software, path: `Classes\CLSID\{32}`, access: []uint32{WOW64_32KEY}, want: `Classes\Wow6432Node\CLSID\{32}`},
+		{name: "user class", registry: classes, path: `.txt`, access: []uint32{WOW64_32KEY}, want: `.txt`},
+		{name: "redirected user class", registry: classes, path: `CLSID\{32}`, access: []uint32{WOW64_32KEY}, want: `Wow6432Node\CLSID\{32}`},
+		{name: "64-bit user class", registry: classes, path: `CLSID\{64}`, access: []uint32{WOW64_64KEY}, want: `CLSID\{64}`},
+		{name: "not redirected", registry: ntuser, path: `Environment`, access: []uint32{WOW64_32KEY}, want: `Environment`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := tt.registry.OpenKey(tt.path, tt.access...)
+			if err != tt.wantErr {
+				t.Fatalf("Registry.OpenKey() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got, _ := k.Path(); got != tt.want {
+				t.Errorf("Registry.OpenKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// subkeys of keys of the 64-bit view
+	windows, err := software.OpenKey(`Microsoft\Windows`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := windows.OpenSubKey(`CurrentVersion\Uninstall`, WOW64_32KEY)
+	if err != nil {
+		t.Fatalf("Key.OpenSubKey() error = %v", err)
+	}
+	if got, _ := k.Path(); got != `WOW6432Node\Microsoft\Windows\CurrentVersion\Uninstall` {
+		t.Errorf("Key.OpenSubKey() = %v", got)
+	}
+	if _, err := k.OpenSubKey("App32", WOW64_32KEY); err != nil {
+		t.Errorf("Key.OpenSubKey() error = %v", err)
+	}
+
+	// a 64-bit key without subkeys
+	vendor, err := software.OpenKey("Vendor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err = vendor.OpenSubKey("App", WOW64_32KEY)
+	if err != nil {
+		t.Fatalf("Key.OpenSubKey() of a key without subkeys error = %v", err)
+	}
+	if got, _ := k.Path(); got != `WOW6432Node\Vendor\App` {
+		t.Errorf("Key.OpenSubKey() = %v", got)
+	}
+	if _, err := vendor.OpenSubKey("App"); err != ErrNotExist {
+		t.Errorf("Key.OpenSubKey() of the 64-bit view error = %v, want %v", err, ErrNotExist)
+	}
+}
@@ -0,0 +1,370 @@
+package registry
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultValueName is the name given to the unnamed value of a key
+	defaultValueName = "(default)"
+
+	// noOffset marks empty lists and missing cells
+	noOffset = 0xffffffff
+
+	// dataInline is set in the data size of values stored in the data offset
+	dataInline = 0x80000000
+)
+
+// SetStringValue sets the data and type of a name value
+// under key k to value and REG_SZ type.
+func (k Key) SetStringValue(name, value string) error {
+	return k.setStringValue(name, REG_SZ, value)
+}
+
+// SetExpandStringValue sets the data and type of a name value
+// under key k to value and REG_EXPAND_SZ type.
+func (k Key) SetExpandStringValue(name, value string) error {
+	return k.setStringValue(name, REG_EXPAND_SZ, value)
+}
+
+func (k Key) setStringValue(name string, valtype uint32, value string) error {
+	if strings.IndexByte(value, 0) >= 0 {
+		return ErrInvalidValue
+	}
+	return k.setValue(name, valtype, append(utf16LEFromString(value), 0, 0))
+}
+
+// SetStringsValue sets the data and type of a name value
+// under key k to value and REG_MULTI_SZ type.
+// The value strings must not contain NUL characters.
+func (k Key) SetStringsValue(name string, value []string) error {
+	b, err := multiStringBytes(value)
+	if err != nil {
+		return err
+	}
+	return k.setValue(name, REG_MULTI_SZ, b)
+}
+
+// multiStringBytes returns the REG_MULTI_SZ data of strings value
+func multiStringBytes(value []string) ([]byte, error) {
+	b := make([]byte, 0)
+	for _, s := range value {
+		if strings.IndexByte(s, 0) >= 0 {
+			return nil, ErrInvalidValue
+		}
+		b = append(b, utf16LEFromString(s)...)
+		b = append(b, 0, 0)
+	}
+	return append(b, 0, 0), nil
+}
+
+// SetDWordValue sets the data and type of a name value
+// under key k to value and REG_DWORD type.
+func (k Key) SetDWordValue(name string, value uint32) error {
+	b, _ := bytesFromUint64LE(uint64(value), REG_DWORD)
+	return k.setValue(name, REG_DWORD, b)
+}
+
+// SetQWordValue sets the data and type of a name value
+// under key k to value and REG_QWORD type.
+func (k Key) SetQWordValue(name string, value uint64) error {
+	b, _ := bytesFromUint64LE(value, REG_QWORD)
+	return k.setValue(name, REG_QWORD, b)
+}
+
+// SetBinaryValue sets the data and type of a name value
+// under key k to value and REG_BINARY type.
+func (k Key) SetBinaryValue(name string, value []byte) error {
+	return k.setValue(name, REG_BINARY, value)
+}
+
+// DeleteValue removes a named value from the key k.
+// If the value does not exist, DeleteValue returns ErrNotExist.
+func (k Key) DeleteValue(name string) error {
+	a := k.registry.cells
+	if a == nil {
+		return ErrReadOnly
+	}
+
+	i, err := k.valueIndex(name)
+	if err != nil {
+		return err
+	}
+
+	list := k.nk.values
+	vk := list.offsets[i]
+	err = freeValueKey(a, vk)
+	if err != nil {
+		return err
+	}
+
+	offsets := append(append([]uint32{}, list.offsets[:i]...), list.offsets[i+1:]...)
+	if len(offsets) == 0 {
+		err = a.free(k.nk.valuesListOffset)
+		k.nk.valuesListOffset = noOffset
+	} else {
+		err = a.writeCell(k.nk.valuesListOffset, bytesFromOffsets(offsets))
+	}
+	if err != nil {
+		return err
+	}
+	k.nk.numberOfValues = uint32(len(offsets))
+	return k.writeNamedKey()
+}
+
+// setValue sets the type and raw data of value name, creating it if needed
+func (k Key) setValue(name string, valtype uint32, data []byte) error {
+	a := k.registry.cells
+	if a == nil {
+		return ErrReadOnly
+	}
+
+	i, err := k.valueIndex(name)
+	if err != nil && err != ErrNotExist {
+		return err
+	}
+
+	size, offset, err := storeValueData(a, data)
+	if err != nil {
+		return err
+	}
+
+	if i >= 0 {
+		vk := k.nk.values.offsets[i]
+		b := make([]byte, 20)
+		err = a.readCell(vk, b)
+		if err != nil {
+			return err
+		}
+		err = freeValueData(a, binary.LittleEndian.Uint32(b[4:8]), binary.LittleEndian.Uint32(b[8:12]))
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(b[4:8], size)
+		binary.LittleEndian.PutUint32(b[8:12], offset)
+		binary.LittleEndian.PutUint32(b[12:16], valtype)
+		err = a.writeCell(vk, b[:16])
+		if err != nil {
+			return err
+		}
+	} else {
+		vk, err := newValueKeyCell(a, name, valtype, size, offset)
+		if err != nil {
+			return err
+		}
+		err = k.appendValue(vk)
+		if err != nil {
+			return err
+		}
+	}
+
+	if n := utf16Size(valueName(name)); n > k.nk.largestValueNameSize {
+		k.nk.largestValueNameSize = n
+	}
+	if n := uint32(len(data)); n > k.nk.largestValueDataSize {
+		k.nk.largestValueDataSize = n
+	}
+	return k.writeNamedKey()
+}
+
+// appendValue adds the value key at offset vk to the value list of k
+func (k Key) appendValue(vk uint32) error {
+	a := k.registry.cells
+	offsets := append(append([]uint32{}, k.nk.values.offsets...), vk)
+	b := bytesFromOffsets(offsets)
+
+	var list uint32
+	var err error
+	if k.nk.numberOfValues == 0 {
+		list, err = a.alloc(len(b))
+	} else {
+		list, err = a.realloc(k.nk.valuesListOffset, len(b))
+	}
+	if err != nil {
+		return err
+	}
+
+	k.nk.valuesListOffset = list
+	k.nk.numberOfValues = uint32(len(offsets))
+	return a.writeCell(list, b)
+}
+
+// writeNamedKey updates the last write time of k, writes it and reads it back
+// to refresh the cached values
+func (k Key) writeNamedKey() error {
+	k.nk.lastModified = filetime(time.Now())
+	err := k.nk.Write()
+	if err != nil {
+		return err
+	}
+	return k.nk.Read()
+}
+
+// valueIndex returns the index of value name in the value list of k,
+// comparing names case insensitively
+func (k Key) valueIndex(name string) (int, error) {
+	if name == "" {
+		name = defaultValueName
+	}
+	list := k.nk.values
+	for i := 0; i < list.Len(); i++ {
+		value, err := list.Value(uint(i))
+		if err != nil {
+			return -1, err
+		}
+		if strings.EqualFold(value.name, name) {
+			return i, nil
+		}
+	}
+	return -1, ErrNotExist
+}
+
+// valueName returns the name stored for value name
+func valueName(name string) string {
+	if name == defaultValueName {
+		return ""
+	}
+	return name
+}
+
+// newValueKeyCell allocates and writes a "vk" cell
+func newValueKeyCell(a *cellAllocator, name string, valtype, size, offset uint32) (uint32, error) {
+	n, compressed := encodeName(valueName(name))
+
+	b := make([]byte, 20+len(n))
+	copy(b, valueKeySig)
+	binary.LittleEndian.PutUint16(b[2:4], uint16(len(n)))
+	binary.LittleEndian.PutUint32(b[4:8], size)
+	binary.LittleEndian.PutUint32(b[8:12], offset)
+	binary.LittleEndian.PutUint32(b[12:16], valtype)
+	if compressed {
+		binary.LittleEndian.PutUint16(b[16:18], vk_VALUE_COMP_NAME)
+	}
+	copy(b[20:], n)
+
+	vk, err := a.alloc(len(b))
+	if err != nil {
+		return 0, err
+	}
+	return vk, a.writeCell(vk, b)
+}
+
+// storeValueData stores data and returns the data size and offset of the "vk" record.
+// Empty data and data of four bytes are stored in the offset itself, data bigger than a
+// segment is split in a "db" record on hives that support it (version 1.4 and up)
+func storeValueData(a *cellAllocator, data []byte) (size, offset uint32, err error) {
+	switch {
+	case len(data) == 0:
+		return dataInline, 0, nil
+	case len(data) == 4:
+		return dataInline | 4, binary.LittleEndian.Uint32(data), nil
+	case len(data) > bigDataMaxSegment && a.header.minor >= 4:
+		offset, err = storeBigData(a, data)
+		return uint32(len(data)), offset, err
+	}
+
+	offset, err = a.alloc(len(data))
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(len(data)), offset, a.writeCell(offset, data)
+}
+
+// storeBigData stores data in segments referenced by a "db" record
+func storeBigData(a *cellAllocator, data []byte) (uint32, error) {
+	var segments []uint32
+	for len(data) > 0 {
+		n := len(data)
+		if n > bigDataMaxSegment {
+			n = bigDataMaxSegment
+		}
+		seg, err := a.alloc(n)
+		if err != nil {
+			return 0, err
+		}
+		err = a.writeCell(seg, data[:n])
+		if err != nil {
+			return 0, err
+		}
+		segments = append(segments, seg)
+		data = data[n:]
+	}
+
+	b := bytesFromOffsets(segments)
+	list, err := a.alloc(len(b))
+	if err != nil {
+		return 0, err
+	}
+	err = a.writeCell(list, b)
+	if err != nil {
+		return 0, err
+	}
+
+	b = make([]byte, 8)
+	copy(b, dataBlockSig)
+	binary.LittleEndian.PutUint16(b[2:4], uint16(len(segments)))
+	binary.LittleEndian.PutUint32(b[4:8], list)
+	db, err := a.alloc(len(b))
+	if err != nil {
+		return 0, err
+	}
+	return db, a.writeCell(db, b)
+}
+
+// freeValueKey frees the "vk" cell at offset and its data
+func freeValueKey(a *cellAllocator, offset uint32) error {
+	b := make([]byte, 12)
+	err := a.readCell(offset, b)
+	if err != nil {
+		return err
+	}
+	err = freeValueData(a, binary.LittleEndian.Uint32(b[4:8]), binary.LittleEndian.Uint32(b[8:12]))
+	if err != nil {
+		return err
+	}
+	return a.free(offset)
+}
+
+// freeValueData frees the cells holding the data of a value
+func freeValueData(a *cellAllocator, size, offset uint32) error {
+	if size&dataInline != 0 || size == 0 {
+		return nil
+	}
+
+	if size > bigDataMaxSegment {
+		b := make([]byte, 8)
+		err := a.readCell(offset, b)
+		if err != nil {
+			return err
+		}
+		if string(b[:2]) == dataBlockSig {
+			list := binary.LittleEndian.Uint32(b[4:8])
+			segments := make([]byte, 4*int(binary.LittleEndian.Uint16(b[2:4])))
+			err = a.readCell(list, segments)
+			if err != nil {
+				return err
+			}
+			for i := 0; i < len(segments); i += 4 {
+				err = a.free(binary.LittleEndian.Uint32(segments[i:]))
+				if err != nil {
+					return err
+				}
+			}
+			err = a.free(list)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return a.free(offset)
+}
+
+func bytesFromOffsets(offsets []uint32) []byte {
+	b := make([]byte, 4*len(offsets))
+	for i, o := range offsets {
+		binary.LittleEndian.PutUint32(b[4*i:], o)
+	}
+	return b
+}
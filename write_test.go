@@ -0,0 +1,349 @@
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testCopy copies file to a temporary directory and returns its path
+// and a function removing it
+func testCopy(t *testing.T, file string) (string, func()) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	name := filepath.Join(dir, filepath.Base(file))
+	dst, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if _, err = io.Copy(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	return name, func() { os.RemoveAll(dir) }
+}
+
+func TestKey_SetValue(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	big := bytes.Repeat([]byte("0123456789abcdef"), 2500)
+	path := `SOFTWARE\Microsoft\InputPersonalization`
+
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := r.OpenKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sets := []struct {
+		name string
+		set  func() error
+	}{
+		{"sz", func() error { return k.SetStringValue("String", "value") }},
+		{"expand sz", func() error { return k.SetExpandStringValue("Expand", `%SystemRoot%\ção`) }},
+		{"multi sz", func() error { return k.SetStringsValue("Multi", []string{"a", "bc"}) }},
+		{"dword", func() error { return k.SetDWordValue("DWord", 0xdeadbeef) }},
+		{"qword", func() error { return k.SetQWordValue("QWord", 1<<40+7) }},
+		{"binary", func() error { return k.SetBinaryValue("Binary", []byte{1, 2, 3}) }},
+		{"big binary", func() error { return k.SetBinaryValue("Big", big) }},
+		{"empty binary", func() error { return k.SetBinaryValue("Empty", nil) }},
+		{"unicode name", func() error { return k.SetStringValue("名前", "x") }},
+		{"replace existing", func() error { return k.SetDWordValue("restrictimplicitinkcollection", 1) }},
+		{"replace big", func() error { return k.SetBinaryValue("Big", big[:100]) }},
+		{"default", func() error { return k.SetStringValue("", "default") }},
+	}
+	for _, s := range sets {
+		if err := s.set(); err != nil {
+			t.Fatalf("%v: error = %v", s.name, err)
+		}
+	}
+	if err := k.DeleteValue("RestrictImplicitTextCollection"); err != nil {
+		t.Fatalf("Key.DeleteValue() error = %v", err)
+	}
+	if err := k.DeleteValue("Missing"); err != ErrNotExist {
+		t.Fatalf("Key.DeleteValue() of missing value error = %v, want %v", err, ErrNotExist)
+	}
+	if err := k.SetStringValue("Nul", "a\x00b"); err != ErrInvalidValue {
+		t.Fatalf("Key.SetStringValue() with NUL error = %v, want %v", err, ErrInvalidValue)
+	}
+	if err := k.SetBinaryValue("Huge", bytes.Repeat([]byte{7}, 40000)); err != nil {
+		t.Fatalf("Key.SetBinaryValue() error = %v", err)
+	}
+	r.Close()
+
+	k, err = OpenKey(file, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	if s, typ, err := k.GetStringValue("String"); s != "value" || typ != REG_SZ || err != nil {
+		t.Errorf("String = %v %v %v", s, typ, err)
+	}
+	if s, typ, err := k.GetStringValue("expand"); s != `%SystemRoot%\ção` || typ != REG_EXPAND_SZ || err != nil {
+		t.Errorf("Expand = %v %v %v", s, typ, err)
+	}
+	if s, _, err := k.GetStringsValue("Multi"); !reflect.DeepEqual(s, []string{"a", "bc"}) || err != nil {
+		t.Errorf("Multi = %v %v", s, err)
+	}
+	if n, typ, err := k.GetIntegerValue("DWord"); n != 0xdeadbeef || typ != REG_DWORD || err != nil {
+		t.Errorf("DWord = %v %v %v", n, typ, err)
+	}
+	if n, typ, err := k.GetIntegerValue("QWord"); n != 1<<40+7 || typ != REG_QWORD || err != nil {
+		t.Errorf("QWord = %v %v %v", n, typ, err)
+	}
+	if n, _, err := k.GetIntegerValue("RestrictImplicitInkCollection"); n != 1 || err != nil {
+		t.Errorf("RestrictImplicitInkCollection = %v %v", n, err)
+	}
+	if b, _, err := k.GetBinaryValue("Binary"); !bytes.Equal(b, []byte{1, 2, 3}) || err != nil {
+		t.Errorf("Binary = %v %v", b, err)
+	}
+	if b, _, err := k.GetBinaryValue("Big"); !bytes.Equal(b, big[:100]) || err != nil {
+		t.Errorf("Big = %v %v", len(b), err)
+	}
+	if b, _, err := k.GetBinaryValue("Empty"); b == nil || len(b) != 0 || err != nil {
+		t.Errorf("Empty = %v %v", b, err)
+	}
+	if b, _, err := k.GetBinaryValue("Huge"); len(b) != 40000 || b[39999] != 7 || err != nil {
+		t.Errorf("Huge = %v %v", len(b), err)
+	}
+	if s, _, err := k.GetStringValue("名前"); s != "x" || err != nil {
+		t.Errorf("名前 = %v %v", s, err)
+	}
+	if s, _, err := k.GetStringValue("(default)"); s != "default" || err != nil {
+		t.Errorf("(default) = %v %v", s, err)
+	}
+	if _, _, err := k.GetIntegerValue("RestrictImplicitTextCollection"); err != ErrNotExist {
+		t.Errorf("deleted value error = %v, want %v", err, ErrNotExist)
+	}
+	names, err := k.ReadValueNames(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 12 {
+		t.Errorf("Key.ReadValueNames() = %v", names)
+	}
+	if k.nk.largestValueDataSize != 40000 {
+		t.Errorf("largestValueDataSize = %v, want 40000", k.nk.largestValueDataSize)
+	}
+
+	// the cells must still be consistent
+	r, err = OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatalf("OpenFile() after changes error = %v", err)
+	}
+	r.Close()
+}
+
+func TestKey_SetValue_readOnly(t *testing.T) {
+	k, err := OpenKey("testdata/NTUSER.DAT", "Environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	if err := k.SetStringValue("TEMP", "x"); err != ErrReadOnly {
+		t.Errorf("Key.SetStringValue() error = %v, want %v", err, ErrReadOnly)
+	}
+	if err := k.DeleteValue("TEMP"); err != ErrReadOnly {
+		t.Errorf("Key.DeleteValue() error = %v, want %v", err, ErrReadOnly)
+	}
+}
+
+func TestCompareKeyNames(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"abc", "ABC", 0},
+		{"a", "B", -1},
+		{"_", "a", 1}, // after 'A'
+		{"ção", "ÇÃO", 0},
+		{"\U0001d49c", "\uff21", -1}, // surrogates sort before U+E000
+		{"é", "z", 1},
+		{"ab", "a", 1},
+	}
+	for _, tt := range tests {
+		if got := compareKeyNames(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareKeyNames(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareKeyNames(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareKeyNames(%q, %q) = %v, want %v", tt.b, tt.a, got, -tt.want)
+		}
+	}
+
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	root, err := r.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, _, err := root.CreateKey("Order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a", "B", "z", "_", "é", "\U0001d49c", "\uff21"}
+	for _, name := range []string{"\uff21", "é", "_", "B", "\U0001d49c", "z", "a"} {
+		if _, _, err := k.CreateKey(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := k.subkeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	els, err := list.allElements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, el := range els {
+		got = append(got, el.namedKey.name)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stored subkeys = %q, want %q", got, want)
+	}
+}
+
+func TestKey_CreateKey(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := r.OpenKey("Environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, existing, err := k.CreateKey(`New\Nested\ção`)
+	if err != nil || existing {
+		t.Fatalf("Key.CreateKey() = %v %v", existing, err)
+	}
+	if err := sub.SetStringValue("Value", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if _, existing, err = k.CreateKey(`new\NESTED`); err != nil || !existing {
+		t.Fatalf("Key.CreateKey() of existing key = %v %v", existing, err)
+	}
+	if _, _, err = k.CreateKey(`\`); err != ErrInvalidName {
+		t.Errorf("Key.CreateKey() of empty name error = %v, want %v", err, ErrInvalidName)
+	}
+
+	// enough keys to split the subkey list in an index root
+	many, _, err := k.CreateKey("Many")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxLeafElements+100; i++ {
+		if _, _, err := many.CreateKey(fmt.Sprintf("Key%04d", (i*7919)%(maxLeafElements+100))); err != nil {
+			t.Fatalf("Key.CreateKey() %v error = %v", i, err)
+		}
+	}
+
+	if err := k.DeleteKey("New"); err != ErrHasSubKeys {
+		t.Errorf("Key.DeleteKey() with subkeys error = %v, want %v", err, ErrHasSubKeys)
+	}
+	if err := k.DeleteKey(`New\Nested\ção`); err != nil {
+		t.Errorf("Key.DeleteKey() error = %v", err)
+	}
+	if err := many.DeleteKey("Key0500"); err != nil {
+		t.Errorf("Key.DeleteKey() error = %v", err)
+	}
+	if err := k.DeleteKey("Missing"); err != ErrNotExist {
+		t.Errorf("Key.DeleteKey() of missing key error = %v, want %v", err, ErrNotExist)
+	}
+	r.Close()
+
+	r, err = OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatalf("OpenFile() after changes error = %v", err)
+	}
+	defer r.Close()
+
+	k, err = r.OpenKey(`Environment\New`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := k.ReadSubKeyNames(-1)
+	if err != nil || !reflect.DeepEqual(names, []string{"Nested"}) {
+		t.Errorf("Key.ReadSubKeyNames() = %v %v", names, err)
+	}
+	if _, err = k.OpenSubKey(`Nested\ção`); err != ErrNotExist {
+		t.Errorf("deleted key error = %v, want %v", err, ErrNotExist)
+	}
+
+	many, err = r.OpenKey(`Environment\Many`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig, _, err := readSubKeyList(r.cells, many.nk.subKeysListOffset); sig != subKeyList4Sig || err != nil {
+		t.Errorf("subkey list = %v %v, want %v", sig, err, subKeyList4Sig)
+	}
+	names, err = many.ReadSubKeyNames(-1)
+	if err != nil || len(names) != maxLeafElements+99 {
+		t.Fatalf("Key.ReadSubKeyNames() = %v %v", len(names), err)
+	}
+	for _, name := range []string{"Key0000", "key0499", "Key0501", "Key1111"} {
+		if _, err := many.OpenSubKey(name); err != nil {
+			t.Errorf("Key.OpenSubKey(%v) error = %v", name, err)
+		}
+	}
+	if _, err := many.OpenSubKey("Key0500"); err != ErrNotExist {
+		t.Errorf("deleted key error = %v, want %v", err, ErrNotExist)
+	}
+
+	var n int
+	it := many.SubKeys()
+	for it.Next() {
+		n++
+	}
+	if it.Err() != nil || n != maxLeafElements+99 {
+		t.Errorf("SubKeyIterator = %v %v", n, it.Err())
+	}
+}
+
+func TestKey_DeleteKey_root(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	k, err := r.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.DeleteKey("Environment"); err != nil {
+		t.Errorf("Key.DeleteKey() error = %v", err)
+	}
+	if _, err := r.OpenKey("Environment"); err != ErrNotExist {
+		t.Errorf("deleted key error = %v, want %v", err, ErrNotExist)
+	}
+}
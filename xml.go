@@ -0,0 +1,417 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Group Policy Preferences class ids
+const (
+	gppSettingsClsid   = "{A3CCFC41-DFDB-43a5-8D26-0FE8B954DA51}"
+	gppCollectionClsid = "{53B533F5-224C-47e3-B01B-CA3B3F3FF4BF}"
+	gppRegistryClsid   = "{9CD4B2F4-923D-47f5-A062-E897DD1DAD50}"
+
+	gppTimeFormat = "2006-01-02 15:04:05"
+)
+
+// Group Policy Preferences actions
+const (
+	XMLCreate  = "C"
+	XMLReplace = "R"
+	XMLUpdate  = "U"
+	XMLDelete  = "D"
+)
+
+// gppTypes are the type names of Group Policy Preferences that differ
+// from the names returned by Type
+var gppTypes = map[uint32]string{
+	REG_DWORD: "REG_DWORD",
+	REG_QWORD: "REG_QWORD",
+}
+
+// XMLOptions are the options of Key.ExportXML
+type XMLOptions struct {
+	// Root is the path written before the path of the keys, its first
+	// name is the hive, like HKEY_LOCAL_MACHINE\SOFTWARE. If empty,
+	// the name of the root key of the registry is used.
+	Root string
+
+	// Action is the action of the items: XMLCreate, XMLReplace,
+	// XMLUpdate, the default, or XMLDelete
+	Action string
+}
+
+type xmlRegistrySettings struct {
+	XMLName     xml.Name          `xml:"RegistrySettings"`
+	Clsid       string            `xml:"clsid,attr"`
+	Items       []xmlRegistryItem `xml:"Registry"`
+	Collections []xmlCollection   `xml:"Collection"`
+}
+
+type xmlCollection struct {
+	Clsid       string            `xml:"clsid,attr"`
+	Name        string            `xml:"name,attr"`
+	Disabled    string            `xml:"disabled,attr,omitempty"`
+	Items       []xmlRegistryItem `xml:"Registry"`
+	Collections []xmlCollection   `xml:"Collection"`
+}
+
+type xmlRegistryItem struct {
+	XMLName    xml.Name      `xml:"Registry"`
+	Clsid      string        `xml:"clsid,attr"`
+	Name       string        `xml:"name,attr"`
+	Status     string        `xml:"status,attr,omitempty"`
+	Image      string        `xml:"image,attr"`
+	Changed    string        `xml:"changed,attr,omitempty"`
+	UID        string        `xml:"uid,attr"`
+	Disabled   string        `xml:"disabled,attr,omitempty"`
+	Properties xmlProperties `xml:"Properties"`
+}
+
+type xmlProperties struct {
+	Action         string     `xml:"action,attr"`
+	DisplayDecimal string     `xml:"displayDecimal,attr"`
+	Default        string     `xml:"default,attr"`
+	Hive           string     `xml:"hive,attr"`
+	Key            string     `xml:"key,attr"`
+	Name           string     `xml:"name,attr"`
+	Type           string     `xml:"type,attr,omitempty"`
+	Value          string     `xml:"value,attr"`
+	Values         *xmlValues `xml:"Values,omitempty"`
+}
+
+type xmlValues struct {
+	Values []string `xml:"Value"`
+}
+
+// ExportXML writes key k, its values and subkeys to w in the registry XML
+// format of Group Policy Preferences: a collection per key with an item
+// per value. Keys without values nor subkeys are written as key items.
+// Values whose data can not be written with their type, like strings
+// with control characters, are written as REG_BINARY.
+func (k Key) ExportXML(w io.Writer, opts *XMLOptions) error {
+	if opts == nil {
+		opts = &XMLOptions{}
+	}
+	action := opts.Action
+	if action == "" {
+		action = XMLUpdate
+	}
+
+	root := strings.Trim(opts.Root, string(separator))
+	if root == "" {
+		root = k.registry.root.name
+	}
+	path, err := k.Path()
+	if err != nil {
+		return err
+	}
+	if path != "" {
+		root += string(separator) + path
+	}
+	hive, key := root, ""
+	if i := strings.IndexByte(root, separator); i >= 0 {
+		hive, key = root[:i], root[i+1:]
+	}
+
+	_, err = io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "\t")
+	settings := xml.StartElement{
+		Name: xml.Name{Local: "RegistrySettings"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "clsid"}, Value: gppSettingsClsid}},
+	}
+	err = e.EncodeToken(settings)
+	if err != nil {
+		return err
+	}
+	x := xmlExporter{e: e, hive: hive, action: action}
+	err = x.exportKey(k, key)
+	if err != nil {
+		return err
+	}
+	err = e.EncodeToken(settings.End())
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// xmlExporter writes the collections and items of keys
+type xmlExporter struct {
+	e      *xml.Encoder
+	hive   string
+	action string
+}
+
+// exportKey writes the collection of key k, known as path in the hive
+func (x xmlExporter) exportKey(k Key, path string) error {
+	k = newKey(k.registry, k.rws, k.nk) // keep the cursor of k
+	name := path[strings.LastIndexByte(path, separator)+1:]
+	if name == "" {
+		name = x.hive
+	}
+	collection := xml.StartElement{
+		Name: xml.Name{Local: "Collection"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "clsid"}, Value: gppCollectionClsid},
+			{Name: xml.Name{Local: "name"}, Value: name},
+		},
+	}
+	err := x.e.EncodeToken(collection)
+	if err != nil {
+		return err
+	}
+
+	changed := date(k.nk.lastModified).Format(gppTimeFormat)
+	values, err := k.ReadValues(-1)
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		err = x.e.Encode(x.valueItem(path, changed, v))
+		if err != nil {
+			return err
+		}
+	}
+
+	names, err := k.ReadSubKeyNames(-1)
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 && len(names) == 0 {
+		err = x.e.Encode(x.item(name, changed, 10, xmlProperties{Key: path}))
+		if err != nil {
+			return err
+		}
+	}
+
+	sort.SliceStable(names, func(i, j int) bool { return compareKeyNames(names[i], names[j]) < 0 })
+	for _, name := range names {
+		sub, err := k.OpenSubKey(name)
+		if err != nil {
+			return err
+		}
+		p := name
+		if path != "" {
+			p = path + string(separator) + name
+		}
+		err = x.exportKey(sub, p)
+		if err != nil {
+			return err
+		}
+	}
+	return x.e.EncodeToken(collection.End())
+}
+
+// valueItem returns the item of value v of key path
+func (x xmlExporter) valueItem(path, changed string, v Value) xmlRegistryItem {
+	p := xmlProperties{Key: path, Name: v.Name, Default: "0"}
+	name := v.Name
+	if v.Name == defaultValueName {
+		p.Name, p.Default = "", "1"
+		name = "(Default)"
+	}
+
+	image := 15
+	raw := v.raw
+	switch v.Type {
+	case REG_SZ, REG_EXPAND_SZ:
+		if s, ok := regString(raw); ok && len(raw) > 0 && xmlString(s) {
+			p.Type, p.Value, image = Type(v.Type), s, 5
+		}
+	case REG_MULTI_SZ:
+		strs := stringsFromBytes(raw)
+		if b, err := multiStringBytes(strs); err == nil && bytes.Equal(b, raw) && xmlString(strings.Join(strs, "")) {
+			p.Type, p.Value, image = Type(v.Type), strings.Join(strs, " "), 5
+			p.Values = &xmlValues{Values: strs}
+		}
+	case REG_DWORD:
+		if len(raw) == 4 {
+			p.Type, p.Value = gppTypes[REG_DWORD], fmt.Sprintf("%08X", binary.LittleEndian.Uint32(raw))
+		}
+	case REG_QWORD:
+		if len(raw) == 8 {
+			p.Type, p.Value = gppTypes[REG_QWORD], fmt.Sprintf("%016X", binary.LittleEndian.Uint64(raw))
+		}
+	default:
+		p.Type, p.Value = Type(v.Type), hex.EncodeToString(raw)
+	}
+	if p.Type == "" {
+		p.Type, p.Value = Type(REG_BINARY), hex.EncodeToString(raw)
+	}
+
+	item := x.item(name, changed, image, p)
+	item.Status = name
+	return item
+}
+
+// item returns the item with properties p. image is the image of the
+// create action, followed by the images of the other actions.
+func (x xmlExporter) item(name, changed string, image int, p xmlProperties) xmlRegistryItem {
+	image += strings.Index(XMLCreate+XMLReplace+XMLUpdate+XMLDelete, x.action)
+	p.Action, p.Hive = x.action, x.hive
+	if p.Default == "" {
+		p.Default = "0"
+	}
+	if p.DisplayDecimal == "" {
+		p.DisplayDecimal = "0"
+	}
+
+	// the same items always get the same uid
+	sum := sha1.Sum([]byte(strings.ToUpper(p.Hive + `\` + p.Key + `\` + p.Name + `\` + p.Default)))
+	uid := fmt.Sprintf("{%X-%X-%X-%X-%X}", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+
+	return xmlRegistryItem{
+		Clsid:      gppRegistryClsid,
+		Name:       name,
+		Image:      strconv.Itoa(image),
+		Changed:    changed,
+		UID:        uid,
+		Properties: p,
+	}
+}
+
+// xmlString reports whether s can be written in XML
+func xmlString(s string) bool {
+	for _, r := range s {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' || r == utf8.RuneError || r == 0xfffe || r == 0xffff {
+			return false
+		}
+	}
+	return true
+}
+
+// ImportXML applies the items of a registry XML file of Group Policy
+// Preferences to registry r. root is the path of the root key of r,
+// hive included, like HKEY_LOCAL_MACHINE\SOFTWARE; items outside of
+// root and disabled items are ignored. Create actions leave existing
+// keys and values unchanged. If the import fails, the registry is left
+// unchanged.
+func (r Registry) ImportXML(rd io.Reader, root string) error {
+	var settings xmlRegistrySettings
+	err := xml.NewDecoder(rd).Decode(&settings)
+	if err != nil {
+		return errorW{err: ErrBadRegistry, cause: err, function: "ImportXML"}
+	}
+
+	keys, err := appendXMLItems(nil, settings.Items, settings.Collections)
+	if err != nil {
+		return errorW{err: ErrBadRegistry, cause: err, function: "ImportXML"}
+	}
+	return r.importKeys(keys, root)
+}
+
+// appendXMLItems appends the keys of items and of the items of
+// collections to keys, skipping disabled ones
+func appendXMLItems(keys []importRecord, items []xmlRegistryItem, collections []xmlCollection) ([]importRecord, error) {
+	for _, item := range items {
+		if item.Disabled == "1" {
+			continue
+		}
+		p := item.Properties
+		path := strings.Trim(p.Hive+string(separator)+p.Key, string(separator))
+
+		if p.Name == "" && p.Default != "1" {
+			// key item
+			switch p.Action {
+			case XMLDelete:
+				keys = append(keys, importRecord{RegKey: RegKey{Path: path, Delete: true}})
+			case XMLReplace:
+				keys = append(keys, importRecord{RegKey: RegKey{Path: path, Delete: true}}, importRecord{RegKey: RegKey{Path: path}})
+			default:
+				keys = append(keys, importRecord{RegKey: RegKey{Path: path}})
+			}
+			continue
+		}
+
+		v := RegValue{Name: p.Name}
+		if p.Action == XMLDelete {
+			v.Delete = true
+		} else {
+			var err error
+			v.Type, v.Data, err = p.data()
+			if err != nil {
+				return nil, err
+			}
+		}
+		keys = append(keys, importRecord{RegKey: RegKey{Path: path, Values: []RegValue{v}}, create: p.Action == XMLCreate})
+	}
+
+	for _, c := range collections {
+		if c.Disabled == "1" {
+			continue
+		}
+		var err error
+		keys, err = appendXMLItems(keys, c.Items, c.Collections)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// data returns the type and data of the value of p
+func (p xmlProperties) data() (uint32, []byte, error) {
+	valtype, ok := xmlType(p.Type)
+	if !ok {
+		return 0, nil, fmt.Errorf("value %q: unknown type %q", p.Name, p.Type)
+	}
+
+	switch valtype {
+	case REG_SZ, REG_EXPAND_SZ:
+		return valtype, append(utf16LEFromString(p.Value), 0, 0), nil
+	case REG_MULTI_SZ:
+		strs := []string{}
+		if p.Values != nil {
+			strs = p.Values.Values
+		} else if p.Value != "" {
+			strs = []string{p.Value}
+		}
+		b, err := multiStringBytes(strs)
+		return valtype, b, err
+	case REG_DWORD, REG_QWORD:
+		bits := 32
+		if valtype == REG_QWORD {
+			bits = 64
+		}
+		n, err := strconv.ParseUint(p.Value, 16, bits)
+		if err != nil {
+			return 0, nil, fmt.Errorf("value %q: %v", p.Name, err)
+		}
+		b, _ := bytesFromUint64LE(n, valtype)
+		return valtype, b, nil
+	}
+	b, err := hex.DecodeString(p.Value)
+	if err != nil {
+		return 0, nil, fmt.Errorf("value %q: %v", p.Name, err)
+	}
+	return valtype, b, nil
+}
+
+// xmlType returns the type named name in Group Policy Preferences or by Type
+func xmlType(name string) (uint32, bool) {
+	for t, n := range gppTypes {
+		if n == name {
+			return t, true
+		}
+	}
+	for t, n := range sType {
+		if n == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
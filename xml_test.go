@@ -0,0 +1,176 @@
+package registry
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKey_ExportXML(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	src, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	root, err := src.OpenKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, _, err := root.CreateKey(`XML\Empty`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, _ = k.Parent()
+	for _, set := range []error{
+		k.SetStringValue("", "<default>"),
+		k.SetStringsValue("Multi", []string{"a", "b c"}),
+		k.SetDWordValue("DWord", 0xabc),
+		k.SetQWordValue("QWord", 1),
+		k.SetBinaryValue("Binary", []byte{1, 0xab}),
+		k.setValue("Control", REG_SZ, append(utf16LEFromString("\x01"), 0, 0)),
+		k.setValue("None", REG_NONE, []byte{1}),
+	} {
+		if set != nil {
+			t.Fatal(set)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := k.ExportXML(&buf, &XMLOptions{Root: `HKEY_CURRENT_USER`}); err != nil {
+		t.Fatalf("Key.ExportXML() error = %v", err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		`<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<RegistrySettings clsid="{A3CCFC41-DFDB-43a5-8D26-0FE8B954DA51}">`,
+		`<Collection clsid="{53B533F5-224C-47e3-B01B-CA3B3F3FF4BF}" name="XML">`,
+		`<Properties action="U" displayDecimal="0" default="1" hive="HKEY_CURRENT_USER" key="XML" name="" type="REG_SZ" value="&lt;default&gt;"></Properties>`,
+		`name="Multi" type="REG_MULTI_SZ" value="a b c">` + "\n\t\t\t\t<Values>\n\t\t\t\t\t<Value>a</Value>\n\t\t\t\t\t<Value>b c</Value>",
+		`name="DWord" type="REG_DWORD" value="00000ABC"`,
+		`name="QWord" type="REG_QWORD" value="0000000000000001"`,
+		`name="Binary" type="REG_BINARY" value="01ab"`,
+		`name="Control" type="REG_BINARY" value="01000000"`,
+		`name="None" type="REG_NONE" value="01"`,
+		`<Registry clsid="{9CD4B2F4-923D-47f5-A062-E897DD1DAD50}" name="Empty" image="12"`,
+		`key="XML\Empty" name="" value=""></Properties>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Key.ExportXML() = %v, want it to contain %v", got, want)
+		}
+	}
+
+	// the whole registry can be imported back
+	buf.Reset()
+	if err := root.ExportXML(&buf, &XMLOptions{Root: `HKEY_CURRENT_USER`}); err != nil {
+		t.Fatalf("Key.ExportXML() error = %v", err)
+	}
+	if err := k.DeleteValue("Control"); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Create(filepath.Join(filepath.Dir(file), "xml.dat"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.ImportXML(&buf, `HKEY_CURRENT_USER`); err != nil {
+		t.Fatalf("Registry.ImportXML() error = %v", err)
+	}
+	// the control characters are imported as REG_BINARY
+	imported, err := d.OpenKey("XML")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, valtype, err := imported.GetBinaryValue("Control"); err != nil || valtype != REG_BINARY || !bytes.Equal(b, []byte{1, 0, 0, 0}) {
+		t.Errorf("Control = %v, %v, %v", b, valtype, err)
+	}
+	if err := imported.DeleteValue("Control"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := testTree(t, d), testTree(t, src); !reflect.DeepEqual(got, want) {
+		t.Errorf("imported registry has %v keys, want %v", len(got), len(want))
+		for path, values := range want {
+			if !reflect.DeepEqual(got[path], values) {
+				t.Errorf("values of %v = %v, want %v", path, got[path], values)
+			}
+		}
+	}
+}
+
+func TestRegistry_ImportXML(t *testing.T) {
+	file, cleanup := testCopy(t, "testdata/NTUSER.DAT")
+	defer cleanup()
+
+	r, err := OpenFile(file, os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	xml := `<?xml version="1.0" encoding="utf-8"?>
+<RegistrySettings clsid="{A3CCFC41-DFDB-43a5-8D26-0FE8B954DA51}">
+	<Registry clsid="{9CD4B2F4-923D-47f5-A062-E897DD1DAD50}" name="Wallpaper" image="8" uid="{1}">
+		<Properties action="D" hive="HKEY_CURRENT_USER" key="Control Panel\Desktop" name="Wallpaper" default="0"/>
+	</Registry>
+	<Collection clsid="{53B533F5-224C-47e3-B01B-CA3B3F3FF4BF}" name="Policies">
+		<Registry clsid="{9CD4B2F4-923D-47f5-A062-E897DD1DAD50}" name="NoRun" image="17" uid="{2}">
+			<Properties action="U" displayDecimal="1" default="0" hive="HKEY_CURRENT_USER" key="Software\Policies\Explorer" name="NoRun" type="REG_DWORD" value="0000000a"/>
+		</Registry>
+		<Registry clsid="{9CD4B2F4-923D-47f5-A062-E897DD1DAD50}" name="NoRun" image="5" uid="{6}">
+			<Properties action="C" displayDecimal="1" default="0" hive="HKEY_CURRENT_USER" key="Software\Policies\Explorer" name="NoRun" type="REG_DWORD" value="00000005"/>
+		</Registry>
+		<Registry clsid="{9CD4B2F4-923D-47f5-A062-E897DD1DAD50}" name="Created" image="5" uid="{7}">
+			<Properties action="C" displayDecimal="1" default="0" hive="HKEY_CURRENT_USER" key="Software\Policies\Explorer" name="Created" type="REG_DWORD" value="00000005"/>
+		</Registry>
+		<Registry clsid="{9CD4B2F4-923D-47f5-A062-E897DD1DAD50}" name="Disabled" image="7" uid="{3}" disabled="1">
+			<Properties action="U" default="0" hive="HKEY_CURRENT_USER" key="Software\Disabled" name="a" type="REG_SZ" value="b"/>
+		</Registry>
+		<Registry clsid="{9CD4B2F4-923D-47f5-A062-E897DD1DAD50}" name="Microsoft" image="13" uid="{4}">
+			<Properties action="D" default="0" hive="HKEY_CURRENT_USER" key="SOFTWARE\Microsoft" name=""/>
+		</Registry>
+		<Registry clsid="{9CD4B2F4-923D-47f5-A062-E897DD1DAD50}" name="Other" image="7" uid="{5}">
+			<Properties action="U" default="0" hive="HKEY_LOCAL_MACHINE" key="Software" name="a" type="REG_SZ" value="b"/>
+		</Registry>
+	</Collection>
+</RegistrySettings>`
+	if err := r.ImportXML(strings.NewReader(xml), "HKEY_CURRENT_USER"); err != nil {
+		t.Fatalf("Registry.ImportXML() error = %v", err)
+	}
+
+	k, err := r.OpenKey(`Control Panel\Desktop`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := k.GetStringValue("Wallpaper"); err != ErrNotExist {
+		t.Errorf("deleted value error = %v, want %v", err, ErrNotExist)
+	}
+	k, err = r.OpenKey(`Software\Policies\Explorer`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _, err := k.GetIntegerValue("NoRun"); err != nil || n != 10 {
+		t.Errorf("NoRun = %v, %v", n, err)
+	}
+	if n, _, err := k.GetIntegerValue("Created"); err != nil || n != 5 {
+		t.Errorf("Created = %v, %v", n, err)
+	}
+	for _, path := range []string{`Software\Disabled`, `SOFTWARE\Microsoft`} {
+		if _, err := r.OpenKey(path); err != ErrNotExist {
+			t.Errorf("OpenKey(%v) error = %v, want %v", path, err, ErrNotExist)
+		}
+	}
+	k, err = r.OpenKey("Software")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := k.GetStringValue("a"); err != ErrNotExist {
+		t.Errorf("value outside of root error = %v, want %v", err, ErrNotExist)
+	}
+	if err := r.ImportXML(strings.NewReader("<RegistrySettings>"), ""); err == nil {
+		t.Errorf("Registry.ImportXML() of invalid XML error = nil")
+	}
+}